@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -26,6 +27,7 @@ type BufferedBulkInserter struct {
 	docCount      int
 	bulkWriteOpts *options.BulkWriteOptions
 	upsert        bool
+	ctx           context.Context
 }
 
 func newBufferedBulkInserter(collection *mongo.Collection, docLimit int, ordered bool) *BufferedBulkInserter {
@@ -34,6 +36,7 @@ func newBufferedBulkInserter(collection *mongo.Collection, docLimit int, ordered
 		bulkWriteOpts: options.BulkWrite().SetOrdered(ordered),
 		docLimit:      docLimit,
 		writeModels:   make([]mongo.WriteModel, 0, docLimit),
+		ctx:           signals.Context(),
 	}
 	return bb
 }
@@ -63,6 +66,14 @@ func (bb *BufferedBulkInserter) SetUpsert(upsert bool) *BufferedBulkInserter {
 	return bb
 }
 
+// SetContext sets the context used for bulk writes, so that a write in
+// progress is interrupted if the context is cancelled. The default is the
+// tool's root context, signals.Context.
+func (bb *BufferedBulkInserter) SetContext(ctx context.Context) *BufferedBulkInserter {
+	bb.ctx = ctx
+	return bb
+}
+
 // throw away the old bulk and init a new one
 func (bb *BufferedBulkInserter) resetBulk() {
 	bb.writeModels = bb.writeModels[:0]
@@ -124,5 +135,5 @@ func (bb *BufferedBulkInserter) Flush() (*mongo.BulkWriteResult, error) {
 	}
 
 	defer bb.resetBulk()
-	return bb.collection.BulkWrite(context.Background(), bb.writeModels, bb.bulkWriteOpts)
+	return bb.collection.BulkWrite(bb.ctx, bb.writeModels, bb.bulkWriteOpts)
 }
@@ -0,0 +1,370 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrChangeStreamInvalidated is returned by a ChangeStreamReader after an
+// invalidate event, e.g. when the watched collection is dropped. The stream
+// can't be resumed past it.
+var ErrChangeStreamInvalidated = errors.New("change stream invalidated")
+
+// ChangeEventNamespace is the namespace of a change event.
+type ChangeEventNamespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll,omitempty"`
+}
+
+func (ns ChangeEventNamespace) String() string {
+	if ns.Coll == "" {
+		return ns.DB
+	}
+	return ns.DB + "." + ns.Coll
+}
+
+// UpdateDescription describes the fields changed by an update event.
+type UpdateDescription struct {
+	UpdatedFields bson.D   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// ChangeEvent represents a change stream event document.
+type ChangeEvent struct {
+	// ID is the event's resume token.
+	ID                bson.Raw             `bson:"_id"`
+	OperationType     string               `bson:"operationType"`
+	ClusterTime       primitive.Timestamp  `bson:"clusterTime"`
+	Namespace         ChangeEventNamespace `bson:"ns"`
+	To                ChangeEventNamespace `bson:"to,omitempty"`
+	DocumentKey       bson.D               `bson:"documentKey,omitempty"`
+	FullDocument      bson.D               `bson:"fullDocument,omitempty"`
+	UpdateDescription *UpdateDescription   `bson:"updateDescription,omitempty"`
+	LSID              bson.Raw             `bson:"lsid,omitempty"`
+	TxnNumber         *int64               `bson:"txnNumber,omitempty"`
+}
+
+// ToOplog converts the event to the oplog entry which would replay it. It
+// returns nil for events which have no oplog equivalent, such as invalidate
+// events and updates which change nothing.
+func (e *ChangeEvent) ToOplog() (*Oplog, error) {
+	op := &Oplog{
+		Timestamp: e.ClusterTime,
+		Version:   2,
+		Namespace: e.Namespace.String(),
+		LSID:      e.LSID,
+		TxnNumber: e.TxnNumber,
+	}
+	commandNS := e.Namespace.DB + ".$cmd"
+
+	switch e.OperationType {
+	case "insert":
+		if e.FullDocument == nil {
+			return nil, fmt.Errorf("insert event on %v has no fullDocument", op.Namespace)
+		}
+		op.Operation = "i"
+		op.Object = e.FullDocument
+	case "update":
+		if e.UpdateDescription == nil {
+			return nil, fmt.Errorf("update event on %v has no updateDescription", op.Namespace)
+		}
+		op.Operation = "u"
+		op.Query = e.DocumentKey
+		if len(e.UpdateDescription.UpdatedFields) > 0 {
+			op.Object = append(op.Object, bson.E{"$set", e.UpdateDescription.UpdatedFields})
+		}
+		if len(e.UpdateDescription.RemovedFields) > 0 {
+			unset := make(bson.D, 0, len(e.UpdateDescription.RemovedFields))
+			for _, field := range e.UpdateDescription.RemovedFields {
+				unset = append(unset, bson.E{field, 1})
+			}
+			op.Object = append(op.Object, bson.E{"$unset", unset})
+		}
+		if len(op.Object) == 0 {
+			return nil, nil
+		}
+	case "replace":
+		if e.FullDocument == nil {
+			return nil, fmt.Errorf("replace event on %v has no fullDocument", op.Namespace)
+		}
+		op.Operation = "u"
+		op.Query = e.DocumentKey
+		op.Object = e.FullDocument
+	case "delete":
+		op.Operation = "d"
+		op.Object = e.DocumentKey
+	case "drop":
+		op.Operation = "c"
+		op.Namespace = commandNS
+		op.Object = bson.D{{"drop", e.Namespace.Coll}}
+	case "rename":
+		op.Operation = "c"
+		op.Namespace = commandNS
+		op.Object = bson.D{
+			{"renameCollection", e.Namespace.String()},
+			{"to", e.To.String()},
+		}
+	case "dropDatabase":
+		op.Operation = "c"
+		op.Namespace = commandNS
+		op.Object = bson.D{{"dropDatabase", 1}}
+	case "invalidate":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported change event operation type '%v'", e.OperationType)
+	}
+	return op, nil
+}
+
+// ChangeStreamSource is the subset of *mongo.ChangeStream used by a
+// ChangeStreamReader, so that a stand-in can be used in place of a server.
+type ChangeStreamSource interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// ChangeStreamOpener opens a change stream which resumes after the given
+// resume token, or starts at the current time if the token is nil.
+type ChangeStreamOpener func(ctx context.Context, resumeToken bson.Raw) (ChangeStreamSource, error)
+
+// ClusterChangeStream returns an opener for a change stream on all
+// non-system collections of the deployment.
+func ClusterChangeStream(client *mongo.Client, pipeline interface{}, opts ...*mopts.ChangeStreamOptions) ChangeStreamOpener {
+	return func(ctx context.Context, resumeToken bson.Raw) (ChangeStreamSource, error) {
+		return client.Watch(ctx, changeStreamPipeline(pipeline), resumeAfter(resumeToken, opts)...)
+	}
+}
+
+// DatabaseChangeStream returns an opener for a change stream on all
+// non-system collections of the database.
+func DatabaseChangeStream(database *mongo.Database, pipeline interface{}, opts ...*mopts.ChangeStreamOptions) ChangeStreamOpener {
+	return func(ctx context.Context, resumeToken bson.Raw) (ChangeStreamSource, error) {
+		return database.Watch(ctx, changeStreamPipeline(pipeline), resumeAfter(resumeToken, opts)...)
+	}
+}
+
+// CollectionChangeStream returns an opener for a change stream on the
+// collection.
+func CollectionChangeStream(coll *mongo.Collection, pipeline interface{}, opts ...*mopts.ChangeStreamOptions) ChangeStreamOpener {
+	return func(ctx context.Context, resumeToken bson.Raw) (ChangeStreamSource, error) {
+		return coll.Watch(ctx, changeStreamPipeline(pipeline), resumeAfter(resumeToken, opts)...)
+	}
+}
+
+func changeStreamPipeline(pipeline interface{}) interface{} {
+	if pipeline == nil {
+		return mongo.Pipeline{}
+	}
+	return pipeline
+}
+
+// resumeAfter appends an option to resume after the token, if there is one,
+// overriding any start point in the caller's options.
+func resumeAfter(resumeToken bson.Raw, opts []*mopts.ChangeStreamOptions) []*mopts.ChangeStreamOptions {
+	if resumeToken == nil {
+		return opts
+	}
+	return append(opts, mopts.ChangeStream().SetResumeAfter(resumeToken))
+}
+
+// ChangeStreamReader reads change events from a change stream and persists
+// the resume token of the last committed event to a file, so that a later
+// reader can pick up where it left off.
+type ChangeStreamReader struct {
+	open      ChangeStreamOpener
+	tokenFile string
+
+	stream ChangeStreamSource
+	event  ChangeEvent
+	// token is the resume token of the last event returned by Next
+	token bson.Raw
+	err   error
+}
+
+// NewChangeStreamReader returns a reader for the change stream opened by
+// open. If tokenFile is non-empty and exists, the stream resumes after the
+// token stored in it. The stream isn't opened until the first call to Next.
+func NewChangeStreamReader(open ChangeStreamOpener, tokenFile string) (*ChangeStreamReader, error) {
+	r := &ChangeStreamReader{open: open, tokenFile: tokenFile}
+	if tokenFile == "" {
+		return r, nil
+	}
+	token, err := ReadResumeToken(tokenFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	r.token = token
+	return r, nil
+}
+
+// Next advances to the next change event, blocking until one is available.
+// It returns false when the stream is exhausted, the context is done or an
+// error occurs, in which case Err returns the error.
+func (r *ChangeStreamReader) Next(ctx context.Context) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.stream == nil {
+		stream, err := r.open(ctx, r.token)
+		if err != nil {
+			r.err = fmt.Errorf("error opening change stream: %v", err)
+			return false
+		}
+		r.stream = stream
+	}
+	if !r.stream.Next(ctx) {
+		if err := r.stream.Err(); err != nil {
+			r.err = fmt.Errorf("error reading change stream: %v", err)
+		} else if err := ctx.Err(); err != nil {
+			r.err = err
+		}
+		return false
+	}
+
+	var event ChangeEvent
+	if err := r.stream.Decode(&event); err != nil {
+		r.err = fmt.Errorf("error decoding change event: %v", err)
+		return false
+	}
+	if event.OperationType == "invalidate" {
+		r.err = ErrChangeStreamInvalidated
+		return false
+	}
+	r.event = event
+	r.token = event.ID
+	return true
+}
+
+// Event returns the event read by the last call to Next.
+func (r *ChangeStreamReader) Event() *ChangeEvent {
+	return &r.event
+}
+
+// Oplog returns the event read by the last call to Next as an oplog entry.
+// See ChangeEvent.ToOplog.
+func (r *ChangeStreamReader) Oplog() (*Oplog, error) {
+	return r.event.ToOplog()
+}
+
+// ResumeToken returns the resume token of the event read by the last call to
+// Next, or the one the reader resumed from if Next hasn't returned an event.
+func (r *ChangeStreamReader) ResumeToken() bson.Raw {
+	return r.token
+}
+
+// Commit persists the resume token of the event read by the last call to
+// Next, so that a reader created with the same file resumes after it. It
+// should be called once the event has been applied.
+func (r *ChangeStreamReader) Commit() error {
+	if r.tokenFile == "" || r.token == nil {
+		return nil
+	}
+	return WriteResumeToken(r.tokenFile, r.token)
+}
+
+// Err returns the error which stopped the reader, if any.
+func (r *ChangeStreamReader) Err() error {
+	return r.err
+}
+
+// Close closes the underlying change stream. The resume token is not
+// committed.
+func (r *ChangeStreamReader) Close(ctx context.Context) error {
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.Close(ctx)
+	r.stream = nil
+	return err
+}
+
+// ReadResumeToken reads a resume token written by WriteResumeToken.
+func ReadResumeToken(path string) (bson.Raw, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var token bson.D
+	if err = bson.UnmarshalExtJSON(data, true, &token); err != nil {
+		return nil, fmt.Errorf("error parsing resume token file %v: %v", path, err)
+	}
+	raw, err := bson.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing resume token file %v: %v", path, err)
+	}
+	return raw, nil
+}
+
+// WriteResumeToken writes a resume token to a file as canonical extended
+// JSON. The file is replaced atomically.
+func WriteResumeToken(path string, token bson.Raw) error {
+	data, err := bson.MarshalExtJSON(token, true, false)
+	if err != nil {
+		return fmt.Errorf("error serializing resume token: %v", err)
+	}
+	if err = writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing resume token file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file's contents by writing them to a temporary
+// file and renaming it, so that a crash never leaves a partial file behind.
+// The directory is synced after the rename, so that once it returns the new
+// contents survive a crash.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the directory's entries to disk. Windows can't sync
+// directories, so it is skipped there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
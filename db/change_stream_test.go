@@ -0,0 +1,269 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeReplSet stands in for a replica set's change stream: it holds a list of
+// events and opens streams which resume after a given token.
+type fakeReplSet struct {
+	events []bson.D
+	opened int
+}
+
+func (rs *fakeReplSet) add(event bson.D) {
+	token := bson.D{{"_data", string(rune('a' + len(rs.events)))}}
+	rs.events = append(rs.events, append(bson.D{{"_id", token}}, event...))
+}
+
+func (rs *fakeReplSet) open(_ context.Context, resumeToken bson.Raw) (ChangeStreamSource, error) {
+	rs.opened++
+	start := 0
+	if resumeToken != nil {
+		start = -1
+		for i, event := range rs.events {
+			id, _ := bson.Marshal(event[0].Value)
+			if bson.Raw(id).String() == resumeToken.String() {
+				start = i + 1
+			}
+		}
+		if start < 0 {
+			return nil, errors.New("resume token not found")
+		}
+	}
+	return &fakeStream{events: rs.events[start:], pos: -1}, nil
+}
+
+type fakeStream struct {
+	events []bson.D
+	pos    int
+	closed bool
+}
+
+func (s *fakeStream) Next(context.Context) bool {
+	if s.pos+1 >= len(s.events) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *fakeStream) Decode(val interface{}) error {
+	raw, err := bson.Marshal(s.events[s.pos])
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, val)
+}
+
+func (s *fakeStream) Err() error { return nil }
+
+func (s *fakeStream) Close(context.Context) error {
+	s.closed = true
+	return nil
+}
+
+func TestChangeEventToOplog(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	ts := primitive.Timestamp{T: 10, I: 2}
+	ns := ChangeEventNamespace{DB: "test", Coll: "foo"}
+
+	Convey("Change events should be converted to oplog entries", t, func() {
+		Convey("for inserts", func() {
+			op, err := (&ChangeEvent{
+				OperationType: "insert",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   bson.D{{"_id", 1}},
+				FullDocument:  bson.D{{"_id", 1}, {"x", 1}},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Operation, ShouldEqual, "i")
+			So(op.Namespace, ShouldEqual, "test.foo")
+			So(op.Timestamp, ShouldResemble, ts)
+			So(op.Object, ShouldResemble, bson.D{{"_id", 1}, {"x", 1}})
+		})
+
+		Convey("for updates", func() {
+			op, err := (&ChangeEvent{
+				OperationType: "update",
+				Namespace:     ns,
+				DocumentKey:   bson.D{{"_id", 1}},
+				UpdateDescription: &UpdateDescription{
+					UpdatedFields: bson.D{{"x", 2}},
+					RemovedFields: []string{"y"},
+				},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Operation, ShouldEqual, "u")
+			So(op.Query, ShouldResemble, bson.D{{"_id", 1}})
+			So(op.Object, ShouldResemble, bson.D{
+				{"$set", bson.D{{"x", 2}}},
+				{"$unset", bson.D{{"y", 1}}},
+			})
+
+			Convey("unless they change nothing", func() {
+				op, err := (&ChangeEvent{
+					OperationType:     "update",
+					Namespace:         ns,
+					DocumentKey:       bson.D{{"_id", 1}},
+					UpdateDescription: &UpdateDescription{},
+				}).ToOplog()
+				So(err, ShouldBeNil)
+				So(op, ShouldBeNil)
+			})
+		})
+
+		Convey("for replacements and deletes", func() {
+			op, err := (&ChangeEvent{
+				OperationType: "replace",
+				Namespace:     ns,
+				DocumentKey:   bson.D{{"_id", 1}},
+				FullDocument:  bson.D{{"_id", 1}, {"z", 3}},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Operation, ShouldEqual, "u")
+			So(op.Query, ShouldResemble, bson.D{{"_id", 1}})
+			So(op.Object, ShouldResemble, bson.D{{"_id", 1}, {"z", 3}})
+
+			op, err = (&ChangeEvent{
+				OperationType: "delete",
+				Namespace:     ns,
+				DocumentKey:   bson.D{{"_id", 1}},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Operation, ShouldEqual, "d")
+			So(op.Object, ShouldResemble, bson.D{{"_id", 1}})
+		})
+
+		Convey("for commands", func() {
+			op, err := (&ChangeEvent{OperationType: "drop", Namespace: ns}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Operation, ShouldEqual, "c")
+			So(op.Namespace, ShouldEqual, "test.$cmd")
+			So(op.Object, ShouldResemble, bson.D{{"drop", "foo"}})
+
+			op, err = (&ChangeEvent{
+				OperationType: "rename",
+				Namespace:     ns,
+				To:            ChangeEventNamespace{DB: "test", Coll: "bar"},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Object, ShouldResemble, bson.D{{"renameCollection", "test.foo"}, {"to", "test.bar"}})
+
+			op, err = (&ChangeEvent{
+				OperationType: "dropDatabase",
+				Namespace:     ChangeEventNamespace{DB: "test"},
+			}).ToOplog()
+			So(err, ShouldBeNil)
+			So(op.Namespace, ShouldEqual, "test.$cmd")
+			So(op.Object, ShouldResemble, bson.D{{"dropDatabase", 1}})
+		})
+
+		Convey("but not for unknown operation types", func() {
+			_, err := (&ChangeEvent{OperationType: "shardCollection", Namespace: ns}).ToOplog()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestChangeStreamReader(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a change stream on a stand-in replica set", t, func() {
+		dir, err := ioutil.TempDir("", "change_stream_test")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+		tokenFile := filepath.Join(dir, "resume_token.json")
+
+		rs := &fakeReplSet{}
+		for i := 0; i < 3; i++ {
+			rs.add(bson.D{
+				{"operationType", "insert"},
+				{"clusterTime", primitive.Timestamp{T: uint32(i + 1)}},
+				{"ns", bson.D{{"db", "test"}, {"coll", "foo"}}},
+				{"documentKey", bson.D{{"_id", i}}},
+				{"fullDocument", bson.D{{"_id", i}}},
+			})
+		}
+		ctx := context.Background()
+
+		Convey("events should be read in order as oplog entries", func() {
+			reader, err := NewChangeStreamReader(rs.open, tokenFile)
+			So(err, ShouldBeNil)
+			var ids []interface{}
+			for reader.Next(ctx) {
+				op, err := reader.Oplog()
+				So(err, ShouldBeNil)
+				So(op.Operation, ShouldEqual, "i")
+				ids = append(ids, op.Object[0].Value)
+			}
+			So(reader.Err(), ShouldBeNil)
+			So(ids, ShouldResemble, []interface{}{int32(0), int32(1), int32(2)})
+			So(reader.Close(ctx), ShouldBeNil)
+		})
+
+		Convey("a new reader should resume after the last committed event", func() {
+			reader, err := NewChangeStreamReader(rs.open, tokenFile)
+			So(err, ShouldBeNil)
+			So(reader.Next(ctx), ShouldBeTrue)
+			So(reader.Next(ctx), ShouldBeTrue)
+			So(reader.Commit(), ShouldBeNil)
+			So(reader.Close(ctx), ShouldBeNil)
+
+			token, err := ReadResumeToken(tokenFile)
+			So(err, ShouldBeNil)
+			So(token.String(), ShouldEqual, reader.ResumeToken().String())
+
+			resumed, err := NewChangeStreamReader(rs.open, tokenFile)
+			So(err, ShouldBeNil)
+			So(resumed.Next(ctx), ShouldBeTrue)
+			So(resumed.Event().ClusterTime, ShouldResemble, primitive.Timestamp{T: 3})
+			So(resumed.Next(ctx), ShouldBeFalse)
+			So(rs.opened, ShouldEqual, 2)
+		})
+
+		Convey("an invalidate event should stop the reader", func() {
+			rs.add(bson.D{{"operationType", "invalidate"}})
+			reader, err := NewChangeStreamReader(rs.open, "")
+			So(err, ShouldBeNil)
+			for reader.Next(ctx) {
+			}
+			So(reader.Err(), ShouldEqual, ErrChangeStreamInvalidated)
+			So(reader.Event().ClusterTime, ShouldResemble, primitive.Timestamp{T: 3})
+		})
+
+		Convey("an unknown resume token should be reported", func() {
+			So(WriteResumeToken(tokenFile, mustMarshal(bson.D{{"_data", "zz"}})), ShouldBeNil)
+			reader, err := NewChangeStreamReader(rs.open, tokenFile)
+			So(err, ShouldBeNil)
+			So(reader.Next(ctx), ShouldBeFalse)
+			So(reader.Err().Error(), ShouldContainSubstring, "resume token not found")
+		})
+	})
+}
+
+func mustMarshal(doc interface{}) bson.Raw {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
@@ -11,8 +11,10 @@ import (
 	"fmt"
 
 	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -57,65 +59,98 @@ type CommandRunner interface {
 // into out.
 
 func (sp *SessionProvider) Run(command interface{}, out interface{}, name string) error {
-	db := sp.DB(name)
-	result := db.RunCommand(context.Background(), command)
-	if result.Err() != nil {
-		return result.Err()
-	}
-	err := result.Decode(out)
-	if err != nil {
-		return err
-	}
-	return nil
+	return sp.RunContext(signals.Context(), command, out, name)
+}
+
+// RunContext is like Run but the command is interrupted if the context is
+// cancelled.
+func (sp *SessionProvider) RunContext(ctx context.Context, command interface{}, out interface{}, name string) error {
+	return sp.withClient(ctx, func(client *mongo.Client) error {
+		result := client.Database(name).RunCommand(ctx, command)
+		if result.Err() != nil {
+			return result.Err()
+		}
+		return result.Decode(out)
+	})
 }
 
 func (sp *SessionProvider) RunString(commandName string, out interface{}, name string) error {
+	return sp.RunStringContext(signals.Context(), commandName, out, name)
+}
+
+func (sp *SessionProvider) RunStringContext(ctx context.Context, commandName string, out interface{}, name string) error {
 	command := &bson.M{commandName: 1}
-	return sp.Run(command, out, name)
+	return sp.RunContext(ctx, command, out, name)
 }
 
 func (sp *SessionProvider) DropDatabase(dbName string) error {
-	return sp.DB(dbName).Drop(context.Background())
+	return sp.DropDatabaseContext(signals.Context(), dbName)
+}
+
+func (sp *SessionProvider) DropDatabaseContext(ctx context.Context, dbName string) error {
+	return sp.withClient(ctx, func(client *mongo.Client) error {
+		return client.Database(dbName).Drop(ctx)
+	})
 }
 
 func (sp *SessionProvider) CreateCollection(dbName, collName string) error {
+	return sp.CreateCollectionContext(signals.Context(), dbName, collName)
+}
+
+func (sp *SessionProvider) CreateCollectionContext(ctx context.Context, dbName, collName string) error {
 	command := &bson.M{"create": collName}
 	out := &bson.Raw{}
-	err := sp.Run(command, out, dbName)
+	err := sp.RunContext(ctx, command, out, dbName)
 	return err
 }
 
+// ServerVersion returns the version string of the connected server, from its
+// cached ServerInfo.
 func (sp *SessionProvider) ServerVersion() (string, error) {
-	out := struct{ Version string }{}
-	err := sp.RunString("buildInfo", &out, "admin")
+	return sp.ServerVersionContext(signals.Context())
+}
+
+// ServerVersionContext is like ServerVersion but the commands are interrupted
+// if the context is cancelled.
+func (sp *SessionProvider) ServerVersionContext(ctx context.Context) (string, error) {
+	info, err := sp.ServerInfoContext(ctx)
 	if err != nil {
 		return "", err
 	}
-	return out.Version, nil
+	return info.VersionString, nil
 }
 
+// ServerVersionArray returns the version of the connected server, from its
+// cached ServerInfo.
 func (sp *SessionProvider) ServerVersionArray() (Version, error) {
-	var version Version
-	out := struct {
-		VersionArray []int32 `bson:"versionArray"`
-	}{}
-	err := sp.RunString("buildInfo", &out, "admin")
+	return sp.ServerVersionArrayContext(signals.Context())
+}
+
+// ServerVersionArrayContext is like ServerVersionArray but the commands are
+// interrupted if the context is cancelled.
+func (sp *SessionProvider) ServerVersionArrayContext(ctx context.Context) (Version, error) {
+	info, err := sp.ServerInfoContext(ctx)
 	if err != nil {
-		return version, fmt.Errorf("error getting buildInfo: %v", err)
-	}
-	if len(out.VersionArray) < 3 {
-		return version, fmt.Errorf("buildInfo.versionArray had fewer than 3 elements")
+		return Version{}, err
 	}
-	for i := 0; i <= 2; i++ {
-		version[i] = int(out.VersionArray[i])
-	}
-	return version, nil
+	return info.Version, nil
 }
 
 // DatabaseNames returns a slice containing the names of all the databases on the
 // connected server.
 func (sp *SessionProvider) DatabaseNames() ([]string, error) {
-	return sp.client.ListDatabaseNames(nil, bson.D{})
+	return sp.DatabaseNamesContext(signals.Context())
+}
+
+// DatabaseNamesContext is like DatabaseNames but the command is interrupted if
+// the context is cancelled.
+func (sp *SessionProvider) DatabaseNamesContext(ctx context.Context) ([]string, error) {
+	var names []string
+	err := sp.withRetries(ctx, func(client *mongo.Client) (err error) {
+		names, err = client.ListDatabaseNames(ctx, bson.D{})
+		return err
+	})
+	return names, err
 }
 
 // CollectionNames returns the names of all the collections in the dbName database.
@@ -128,42 +163,31 @@ func (sp *SessionProvider) DatabaseNames() ([]string, error) {
 // }
 
 // GetNodeType checks if the connected SessionProvider is a mongos, standalone, or replset,
-// by looking at the result of calling isMaster.
+// from its cached ServerInfo.
 func (sp *SessionProvider) GetNodeType() (NodeType, error) {
-	session, err := sp.GetSession()
-	if err != nil {
-		return Unknown, err
-	}
-	masterDoc := struct {
-		SetName interface{} `bson:"setName"`
-		Hosts   interface{} `bson:"hosts"`
-		Msg     string      `bson:"msg"`
-	}{}
-	result := session.Database("admin").RunCommand(
-		context.Background(),
-		&bson.M{"ismaster": 1},
-	)
-	if result.Err() != nil {
-		return Unknown, result.Err()
-	}
-	err = result.Decode(&masterDoc)
+	return sp.GetNodeTypeContext(signals.Context())
+}
+
+// GetNodeTypeContext is like GetNodeType but the commands are interrupted if
+// the context is cancelled.
+func (sp *SessionProvider) GetNodeTypeContext(ctx context.Context) (NodeType, error) {
+	info, err := sp.ServerInfoContext(ctx)
 	if err != nil {
 		return Unknown, err
 	}
-	if masterDoc.SetName != nil || masterDoc.Hosts != nil {
-		return ReplSet, nil
-	} else if masterDoc.Msg == "isdbgrid" {
-		// isdbgrid is always the msg value when calling isMaster on a mongos
-		// see http://docs.mongodb.org/manual/core/sharded-cluster-query-router/
-		return Mongos, nil
-	}
-	return Standalone, nil
+	return info.NodeType, nil
 }
 
 // IsReplicaSet returns a boolean which is true if the connected server is part
 // of a replica set.
 func (sp *SessionProvider) IsReplicaSet() (bool, error) {
-	nodeType, err := sp.GetNodeType()
+	return sp.IsReplicaSetContext(signals.Context())
+}
+
+// IsReplicaSetContext is like IsReplicaSet but the command is interrupted if
+// the context is cancelled.
+func (sp *SessionProvider) IsReplicaSetContext(ctx context.Context) (bool, error) {
+	nodeType, err := sp.GetNodeTypeContext(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -172,7 +196,13 @@ func (sp *SessionProvider) IsReplicaSet() (bool, error) {
 
 // IsMongos returns true if the connected server is a mongos.
 func (sp *SessionProvider) IsMongos() (bool, error) {
-	nodeType, err := sp.GetNodeType()
+	return sp.IsMongosContext(signals.Context())
+}
+
+// IsMongosContext is like IsMongos but the command is interrupted if the
+// context is cancelled.
+func (sp *SessionProvider) IsMongosContext(ctx context.Context) (bool, error) {
+	nodeType, err := sp.GetNodeTypeContext(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -225,11 +255,12 @@ func (sp *SessionProvider) IsMongos() (bool, error) {
 // FindOne retuns the first document in the collection and database that matches
 // the query after skip, sort and query flags are applied.
 func (sp *SessionProvider) FindOne(db, collection string, skip int, query interface{}, sort interface{}, into interface{}, flags int) error {
-	session, err := sp.GetSession()
-	if err != nil {
-		return err
-	}
+	return sp.FindOneContext(signals.Context(), db, collection, skip, query, sort, into, flags)
+}
 
+// FindOneContext is like FindOne but the query is interrupted if the context
+// is cancelled.
+func (sp *SessionProvider) FindOneContext(ctx context.Context, db, collection string, skip int, query interface{}, sort interface{}, into interface{}, flags int) error {
 	if query == nil {
 		query = bson.D{}
 	}
@@ -237,9 +268,10 @@ func (sp *SessionProvider) FindOne(db, collection string, skip int, query interf
 	opts := mopt.FindOne().SetSort(sort).SetSkip(int64(skip))
 	ApplyFlags(opts, flags)
 
-	res := session.Database(db).Collection(collection).FindOne(nil, query, opts)
-	err = res.Decode(into)
-	return err
+	return sp.withRetries(ctx, func(session *mongo.Client) error {
+		res := session.Database(db).Collection(collection).FindOne(ctx, query, opts)
+		return res.Decode(into)
+	})
 }
 
 // ApplyFlags applies flags to the given query session.
@@ -256,6 +288,12 @@ func ApplyFlags(opts *mopt.FindOneOptions, flags int) {
 // For versions that support collection UUIDs (<3.6) it uses an insert to system indexes.
 // Later versions use the createIndexes command.
 func (sp *SessionProvider) RunApplyOpsCreateIndex(C, DB string, index bson.D, UUID *primitive.Binary, result *interface{}) error {
+	return sp.RunApplyOpsCreateIndexContext(signals.Context(), C, DB, index, UUID, result)
+}
+
+// RunApplyOpsCreateIndexContext is like RunApplyOpsCreateIndex but the command
+// is interrupted if the context is cancelled.
+func (sp *SessionProvider) RunApplyOpsCreateIndexContext(ctx context.Context, C, DB string, index bson.D, UUID *primitive.Binary, result *interface{}) error {
 	var op Oplog
 
 	// Add an index version if it is missing. An index version could be missing because
@@ -283,7 +321,7 @@ func (sp *SessionProvider) RunApplyOpsCreateIndex(C, DB string, index bson.D, UU
 		}
 	}
 
-	err = sp.Run(bson.D{{Key: "applyOps", Value: []Oplog{op}}}, result, DB)
+	err = sp.RunContext(ctx, bson.D{{Key: "applyOps", Value: []Oplog{op}}}, result, DB)
 	if err != nil {
 		return fmt.Errorf("error building index: %v", err)
 	}
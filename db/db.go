@@ -11,23 +11,28 @@ package db
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mongodb/mongo-tools-common/log"
 	"github.com/mongodb/mongo-tools-common/options"
-	"github.com/mongodb/mongo-tools-common/password"
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 type (
@@ -83,25 +88,125 @@ type SessionProvider struct {
 
 	// the master client used for operations
 	client *mongo.Client
+
+	// monitor, if set, tracks whether a primary is available
+	monitor *HealthMonitor
+
+	// serverInfo caches the server's information, guarded by infoLock
+	infoLock   sync.Mutex
+	serverInfo *ServerInfo
 }
 
 // Returns a mongo.Client connected to the database server for which the
-// session provider is configured.
+// session provider is configured. If the health monitor is running, GetSession
+// waits for a primary to be available, up to the monitor's timeout.
 func (sp *SessionProvider) GetSession() (*mongo.Client, error) {
+	return sp.GetSessionContext(signals.Context())
+}
+
+// GetSessionContext is like GetSession but stops waiting for a primary if the
+// context is cancelled.
+func (sp *SessionProvider) GetSessionContext(ctx context.Context) (*mongo.Client, error) {
 	sp.Lock()
-	defer sp.Unlock()
+	client, monitor := sp.client, sp.monitor
+	sp.Unlock()
 
-	if sp.client == nil {
+	if client == nil {
 		return nil, errors.New("SessionProvider already closed")
 	}
+	if monitor != nil {
+		if err := monitor.WaitForPrimary(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-	return sp.client, nil
+	return client, nil
+}
+
+// withClient runs op with the client once a primary is available. If op fails
+// because the connection or the primary was lost, the health monitor is told,
+// but op is not retried, since the server may have applied a write before the
+// connection was lost.
+func (sp *SessionProvider) withClient(ctx context.Context, op func(*mongo.Client) error) error {
+	client, err := sp.GetSessionContext(ctx)
+	if err != nil {
+		return err
+	}
+	err = op(client)
+	if IsConnectionError(err) {
+		sp.Lock()
+		monitor := sp.monitor
+		sp.Unlock()
+		if monitor != nil {
+			monitor.NotifyError(err)
+		}
+	}
+	return err
+}
+
+// withRetries is like withClient but, while the health monitor is running, an
+// op which fails because the connection or the primary was lost is retried
+// once a primary is available again, for up to the monitor's timeout. It must
+// only be used for reads and other operations which are safe to repeat.
+func (sp *SessionProvider) withRetries(ctx context.Context, op func(*mongo.Client) error) error {
+	var deadline time.Time
+	for {
+		client, err := sp.GetSessionContext(ctx)
+		if err != nil {
+			return err
+		}
+		err = op(client)
+
+		sp.Lock()
+		monitor := sp.monitor
+		sp.Unlock()
+		if monitor == nil || !IsConnectionError(err) {
+			return err
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(monitor.timeout)
+		} else if time.Now().After(deadline) {
+			return err
+		}
+
+		log.Logvf(log.Info, "retrying after losing the connection: %v", err)
+		monitor.NotifyError(err)
+		if monitor.State() == Connected {
+			// the primary is back or was never lost, so pause before retrying
+			// rather than retrying in a tight loop
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(monitor.interval):
+			}
+		}
+	}
+}
+
+// StartHealthMonitor starts checking for a primary every interval, so that
+// GetSession pauses callers while none is available, such as during an
+// election, for at most timeout, and the SessionProvider's reads are retried
+// if they fail because the primary was lost. The returned monitor can be subscribed to
+// for changes in the connection state. It is stopped by Close.
+func (sp *SessionProvider) StartHealthMonitor(interval, timeout time.Duration) *HealthMonitor {
+	sp.Lock()
+	defer sp.Unlock()
+
+	if sp.monitor == nil {
+		sp.monitor = NewHealthMonitor(sp.client, interval, timeout)
+		sp.monitor.Start()
+	}
+	return sp.monitor
 }
 
 // Close closes the master session in the connection pool
 func (sp *SessionProvider) Close() {
 	sp.Lock()
 	defer sp.Unlock()
+	if sp.monitor != nil {
+		sp.monitor.Stop()
+		sp.monitor = nil
+	}
 	if sp.client != nil {
 		_ = sp.client.Disconnect(context.Background())
 		sp.client = nil
@@ -115,9 +220,19 @@ func (sp *SessionProvider) DB(name string) *mongo.Database {
 
 // NewSessionProvider constructs a session provider, including a connected client.
 func NewSessionProvider(opts options.ToolOptions) (*SessionProvider, error) {
+	return NewSessionProviderContext(signals.Context(), opts)
+}
+
+// NewSessionProviderContext is like NewSessionProvider but connecting to the
+// server is interrupted if the context is cancelled.
+func NewSessionProviderContext(ctx context.Context, opts options.ToolOptions) (*SessionProvider, error) {
 	// finalize auth options, filling in missing passwords
 	if opts.Auth.ShouldAskForPassword() {
-		pass, err := password.Prompt()
+		provider, err := opts.Auth.GetPasswordProvider()
+		if err != nil {
+			return nil, err
+		}
+		pass, err := provider.Password()
 		if err != nil {
 			return nil, fmt.Errorf("error reading password: %v", err)
 		}
@@ -128,11 +243,11 @@ func NewSessionProvider(opts options.ToolOptions) (*SessionProvider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error configuring the connector: %v", err)
 	}
-	err = client.Connect(context.Background())
+	err = client.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = client.Ping(context.Background(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to server: %v", err)
 	}
@@ -142,15 +257,42 @@ func NewSessionProvider(opts options.ToolOptions) (*SessionProvider, error) {
 }
 
 // addClientCertFromFile adds a client certificate to the configuration given a path to the
-// containing file and returns the certificate's subject name.
+// containing file and returns the certificate's subject name. The file may either contain
+// PEM-encoded certificate and private key blocks or be a PKCS#12 bundle.
 func addClientCertFromFile(cfg *tls.Config, clientFile, keyPasswd string) (string, error) {
 	data, err := ioutil.ReadFile(clientFile)
 	if err != nil {
 		return "", err
 	}
 
+	var cert tls.Certificate
+	if block, _ := pem.Decode(data); block == nil {
+		// a file without any PEM blocks is taken to be a PKCS#12 bundle
+		cert, err = loadPKCS12(data, keyPasswd)
+	} else {
+		cert, err = loadPEMKeyPair(data, keyPasswd)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	cfg.Certificates = append(cfg.Certificates, cert)
+
+	// The documentation for the tls.X509KeyPair indicates that the Leaf certificate is not
+	// retained.
+	crt, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+
+	return crt.Subject.String(), nil
+}
+
+// loadPEMKeyPair loads a certificate and private key from PEM blocks, decrypting the key
+// with the given password if it was encrypted with either legacy PEM encryption or PKCS#8.
+func loadPEMKeyPair(data []byte, keyPasswd string) (tls.Certificate, error) {
 	var currentBlock *pem.Block
-	var certBlock, certDecodedBlock, keyBlock []byte
+	var certBlock, keyBlock []byte
 
 	remaining := data
 	start := 0
@@ -162,14 +304,27 @@ func addClientCertFromFile(cfg *tls.Config, clientFile, keyPasswd string) (strin
 
 		if currentBlock.Type == "CERTIFICATE" {
 			certBlock = data[start : len(data)-len(remaining)]
-			certDecodedBlock = currentBlock.Bytes
 			start += len(certBlock)
+		} else if currentBlock.Type == "ENCRYPTED PRIVATE KEY" {
+			buf, err := decryptPKCS8(currentBlock.Bytes, keyPasswd)
+			if err != nil {
+				return tls.Certificate{}, err
+			}
+
+			keyBlock = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: buf})
+			start = len(data) - len(remaining)
 		} else if strings.HasSuffix(currentBlock.Type, "PRIVATE KEY") {
-			if keyPasswd != "" && x509.IsEncryptedPEMBlock(currentBlock) {
+			if x509.IsEncryptedPEMBlock(currentBlock) {
+				if keyPasswd == "" {
+					return tls.Certificate{}, errors.New("private key is encrypted but no password was given")
+				}
+
 				var encoded bytes.Buffer
 				buf, err := x509.DecryptPEMBlock(currentBlock, []byte(keyPasswd))
-				if err != nil {
-					return "", err
+				if err == x509.IncorrectPasswordError {
+					return tls.Certificate{}, errIncorrectKeyPassword
+				} else if err != nil {
+					return tls.Certificate{}, err
 				}
 
 				pem.Encode(&encoded, &pem.Block{Type: currentBlock.Type, Bytes: buf})
@@ -182,26 +337,80 @@ func addClientCertFromFile(cfg *tls.Config, clientFile, keyPasswd string) (strin
 		}
 	}
 	if len(certBlock) == 0 {
-		return "", fmt.Errorf("failed to find CERTIFICATE")
+		return tls.Certificate{}, fmt.Errorf("failed to find CERTIFICATE")
 	}
 	if len(keyBlock) == 0 {
-		return "", fmt.Errorf("failed to find PRIVATE KEY")
+		return tls.Certificate{}, fmt.Errorf("failed to find PRIVATE KEY")
 	}
 
-	cert, err := tls.X509KeyPair(certBlock, keyBlock)
-	if err != nil {
-		return "", err
+	return tls.X509KeyPair(certBlock, keyBlock)
+}
+
+// loadPKCS12 loads a certificate, its private key and any chain certificates from a
+// PKCS#12 bundle encrypted with the given password.
+func loadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	switch err.(type) {
+	case nil:
+	case pkcs12.NotImplementedError:
+		return tls.Certificate{}, fmt.Errorf("unsupported PKCS#12 file: %v", err)
+	default:
+		if err == pkcs12.ErrIncorrectPassword {
+			return tls.Certificate{}, errors.New("incorrect password for PKCS#12 file")
+		}
+		return tls.Certificate{}, fmt.Errorf("file is neither PEM nor a valid PKCS#12 bundle: %v", err)
 	}
 
-	cfg.Certificates = append(cfg.Certificates, cert)
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
 
-	// The documentation for the tls.X509KeyPair indicates that the Leaf certificate is not
-	// retained.
-	crt, err := x509.ParseCertificate(certDecodedBlock)
+	// check that the key belongs to the certificate, as tls.X509KeyPair does for PEM files
+	public, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("unsupported private key type %T in PKCS#12 file", key)
+	}
+	if !publicKeysEqual(public.Public(), leaf.PublicKey) {
+		return tls.Certificate{}, errors.New("private key in PKCS#12 file does not match its certificate")
+	}
+	return cert, nil
+}
+
+// publicKeysEqual returns true if the two public keys are the same key.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.N.Cmp(b.N) == 0 && a.E == b.E
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.Curve == b.Curve && a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+	}
+	// Ed25519 keys are byte slices, compared by reflection since
+	// crypto/ed25519 is not available on every supported toolchain
+	if a == nil || b == nil {
+		return false
+	}
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	return av.Type() == bv.Type() && av.Kind() == reflect.Slice &&
+		av.Type().Elem().Kind() == reflect.Uint8 && bytes.Equal(av.Bytes(), bv.Bytes())
+}
+
+// clientCertSubject returns the subject name of the client certificate in the
+// TLS configuration, for use as the MONGODB-X509 username.
+func clientCertSubject(cfg *tls.Config) (string, error) {
+	if cfg == nil || len(cfg.Certificates) == 0 {
+		return "", fmt.Errorf("the %v mechanism requires a client certificate", options.X509Mechanism)
+	}
+	crt, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
 	if err != nil {
 		return "", err
 	}
-
 	return crt.Subject.String(), nil
 }
 
@@ -309,6 +518,22 @@ func configureClient(opts options.ToolOptions) (*mongo.Client, error) {
 		clientopt.SetCompressors(strings.Split(opts.Compressors, ","))
 	}
 
+	var tlsConfig *tls.Config
+	if opts.SSL != nil {
+		// options built without ParseArgs may only have the tls aliases set
+		if err := opts.SSL.NormalizeTLSAliases(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.SSL != nil && opts.UseSSL {
+		var err error
+		tlsConfig, err = configureTLS(opts.SSL)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring client, %v", err)
+		}
+		clientopt.SetTLSConfig(tlsConfig)
+	}
+
 	if opts.Auth != nil && opts.Auth.IsSet() {
 		cred := mopt.Credential{
 			Username:      opts.Auth.Username,
@@ -321,42 +546,23 @@ func configureClient(opts options.ToolOptions) (*mongo.Client, error) {
 		if cred.Password != "" {
 			cred.PasswordSet = true
 		}
-		if opts.Kerberos != nil && cred.AuthMechanism == "GSSAPI" {
-			props := make(map[string]string)
-			if opts.Kerberos.Service != "" {
-				props["SERVICE_NAME"] = opts.Kerberos.Service
+		switch {
+		case opts.Kerberos != nil && opts.Auth.IsMechanism(options.GSSAPIMechanism):
+			if err := opts.Kerberos.Validate(); err != nil {
+				return nil, err
 			}
-			// XXX How do we use opts.Kerberos.ServiceHost if at all?
-			cred.AuthMechanismProperties = props
-		}
-		clientopt.SetAuth(cred)
-	}
-
-	if opts.SSL != nil && opts.UseSSL {
-		// Error on unsupported features
-		if opts.SSLFipsMode {
-			return nil, fmt.Errorf("FIPS mode not supported")
-		}
-		if opts.SSLCRLFile != "" {
-			return nil, fmt.Errorf("CRL files are not supported on this platform")
-		}
-
-		tlsConfig := &tls.Config{}
-		if opts.SSLAllowInvalidCert || opts.SSLAllowInvalidHost || opts.TLSInsecure {
-			tlsConfig.InsecureSkipVerify = true
-		}
-		if opts.SSLPEMKeyFile != "" {
-			_, err := addClientCertFromFile(tlsConfig, opts.SSLPEMKeyFile, opts.SSLPEMKeyPassword)
+			cred.AuthMechanismProperties = opts.Kerberos.MechanismProperties()
+		case opts.Auth.IsMechanism(options.AWSMechanism):
+			return nil, fmt.Errorf("the %v authentication mechanism is not supported by this driver", options.AWSMechanism)
+		case opts.Auth.IsMechanism(options.X509Mechanism) && cred.Username == "":
+			// the user is named by the subject of the client certificate
+			subject, err := clientCertSubject(tlsConfig)
 			if err != nil {
-				return nil, fmt.Errorf("error configuring client, can't load client certificate: %v", err)
+				return nil, fmt.Errorf("error configuring client, %v", err)
 			}
+			cred.Username = subject
 		}
-		if opts.SSLCAFile != "" {
-			if err := addCACertFromFile(tlsConfig, opts.SSLCAFile); err != nil {
-				return nil, fmt.Errorf("error configuring client, can't load CA file: %v", err)
-			}
-		}
-		clientopt.SetTLSConfig(tlsConfig)
+		clientopt.SetAuth(cred)
 	}
 
 	return mongo.NewClient(uriOpts, clientopt)
@@ -417,15 +623,22 @@ func CanIgnoreError(err error) bool {
 }
 
 // IsMMAPV1 returns whether the storage engine is MMAPV1. Also returns false
-// if the storage engine type cannot be determined for some reason.
+// if the storage engine type cannot be determined for some reason. Callers
+// with a SessionProvider should use its ServerInfo instead.
 func IsMMAPV1(database *mongo.Database, collectionName string) (bool, error) {
+	return IsMMAPV1Context(signals.Context(), database, collectionName)
+}
+
+// IsMMAPV1Context is like IsMMAPV1 but the command is interrupted if the
+// context is cancelled.
+func IsMMAPV1Context(ctx context.Context, database *mongo.Database, collectionName string) (bool, error) {
 	// mmapv1 does not announce itself like other storage engines. Instead,
 	// we check for the key 'numExtents', which only occurs on MMAPV1.
 	const numExtents = "numExtents"
 
 	var collStats map[string]interface{}
 
-	singleRes := database.RunCommand(context.Background(), bson.M{"collStats": collectionName})
+	singleRes := database.RunCommand(ctx, bson.M{"collStats": collectionName})
 
 	if err := singleRes.Err(); err != nil {
 		return false, err
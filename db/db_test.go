@@ -266,3 +266,24 @@ func TestServerVersionArray(t *testing.T) {
 		So(version.GT(Version{}), ShouldBeTrue)
 	})
 }
+
+func TestConfigureClientAWS(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Configuring a client for MONGODB-AWS should fail clearly", t, func() {
+		opts := options.ToolOptions{
+			General:    &options.General{},
+			Connection: &options.Connection{Host: "localhost", Port: DefaultTestPort},
+			URI:        &options.URI{},
+			SSL:        &options.SSL{},
+			Auth: &options.Auth{
+				Username:  "key",
+				Password:  "secret",
+				Mechanism: options.AWSMechanism,
+			},
+		}
+		_, err := configureClient(opts)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "not supported by this driver")
+	})
+}
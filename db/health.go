@@ -0,0 +1,279 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Defaults for the health monitor.
+const (
+	DefaultHealthCheckInterval = 2 * time.Second
+	DefaultPrimaryWaitTimeout  = 2 * time.Minute
+)
+
+// ConnectionState describes whether the server can currently be used.
+type ConnectionState int
+
+// Connection states reported by a HealthMonitor.
+const (
+	// Connected means a primary, or a standalone or mongos, is available.
+	Connected ConnectionState = iota
+	// NoPrimary means the server can't be reached or, for a replica set, no
+	// primary is available, e.g. during an election.
+	NoPrimary
+	// MonitorStopped means the monitor has been stopped and no longer reports
+	// the state of the connection.
+	MonitorStopped
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case NoPrimary:
+		return "no primary"
+	case MonitorStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// ConnectionEvent reports a change in the connection state.
+type ConnectionEvent struct {
+	Previous ConnectionState
+	State    ConnectionState
+	Time     time.Time
+	// Err is the error from the health check which caused a loss of the
+	// primary, if any.
+	Err error
+}
+
+func (e ConnectionEvent) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("connection state changed from %v to %v: %v", e.Previous, e.State, e.Err)
+	}
+	return fmt.Sprintf("connection state changed from %v to %v", e.Previous, e.State)
+}
+
+// ErrMonitorStopped is returned when waiting for a primary on a stopped
+// HealthMonitor.
+var ErrMonitorStopped = errors.New("health monitor stopped")
+
+// HealthMonitor periodically checks that a primary is available and lets
+// callers wait for one to become available again, e.g. after a step-down.
+type HealthMonitor struct {
+	sync.Mutex
+
+	check    func(ctx context.Context) error
+	interval time.Duration
+	timeout  time.Duration
+
+	state   ConnectionState
+	lastErr error
+	// ready is closed while a primary is available
+	ready     chan struct{}
+	listeners []func(ConnectionEvent)
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewHealthMonitor returns a monitor which checks the client for a primary
+// every interval, and whose WaitForPrimary calls give up after timeout. The
+// client is assumed to be connected initially. The monitor must be started
+// with Start.
+func NewHealthMonitor(client *mongo.Client, interval, timeout time.Duration) *HealthMonitor {
+	return newHealthMonitor(func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}, interval, timeout)
+}
+
+func newHealthMonitor(check func(ctx context.Context) error, interval, timeout time.Duration) *HealthMonitor {
+	ready := make(chan struct{})
+	close(ready)
+	return &HealthMonitor{
+		check:    check,
+		interval: interval,
+		timeout:  timeout,
+		state:    Connected,
+		ready:    ready,
+		done:     make(chan struct{}),
+	}
+}
+
+// Subscribe registers a function to be called with every change in the
+// connection state. Listeners are called in order from the monitor's
+// goroutine and should not block.
+func (m *HealthMonitor) Subscribe(listener func(ConnectionEvent)) {
+	m.Lock()
+	defer m.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Start begins checking the connection in the background.
+func (m *HealthMonitor) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+			}
+			m.checkOnce()
+		}
+	}()
+}
+
+// Stop stops the monitor. Any callers waiting for a primary are released
+// with ErrMonitorStopped.
+func (m *HealthMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+		m.setState(MonitorStopped, nil)
+	})
+}
+
+// State returns the current connection state.
+func (m *HealthMonitor) State() ConnectionState {
+	m.Lock()
+	defer m.Unlock()
+	return m.state
+}
+
+// NotifyError tells the monitor about an error returned by an operation. If
+// it indicates a lost connection or primary, the connection is checked right
+// away rather than at the next interval, and the state reflects the check
+// when NotifyError returns.
+func (m *HealthMonitor) NotifyError(err error) {
+	if !IsConnectionError(err) || m.State() == MonitorStopped {
+		return
+	}
+	m.checkOnce()
+}
+
+// WaitForPrimary returns once a primary is available, or an error if none
+// becomes available within the monitor's timeout or the context is done.
+func (m *HealthMonitor) WaitForPrimary(ctx context.Context) error {
+	m.Lock()
+	ready, state := m.ready, m.state
+	m.Unlock()
+	if state == MonitorStopped {
+		return ErrMonitorStopped
+	}
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-m.done:
+		return ErrMonitorStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		m.Lock()
+		defer m.Unlock()
+		return fmt.Errorf("no primary available after %v: %v", m.timeout, m.lastErr)
+	}
+}
+
+// checkOnce runs a single health check and updates the state.
+func (m *HealthMonitor) checkOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+	defer cancel()
+	if err := m.check(ctx); err != nil {
+		m.setState(NoPrimary, err)
+	} else {
+		m.setState(Connected, nil)
+	}
+}
+
+// setState records the new state and notifies the listeners if it changed.
+func (m *HealthMonitor) setState(state ConnectionState, err error) {
+	m.Lock()
+	m.lastErr = err
+	previous := m.state
+	if previous == state || previous == MonitorStopped {
+		m.Unlock()
+		return
+	}
+	m.state = state
+	if state == Connected {
+		close(m.ready)
+	} else if previous == Connected {
+		m.ready = make(chan struct{})
+	}
+	listeners := m.listeners
+	m.Unlock()
+
+	event := ConnectionEvent{Previous: previous, State: state, Time: time.Now(), Err: err}
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// LogConnectionEvent logs a change in the connection state. It can be passed
+// to HealthMonitor.Subscribe.
+func LogConnectionEvent(event ConnectionEvent) {
+	log.Logvf(log.Always, "%v", event)
+}
+
+// IsConnectionError returns whether the error indicates that the connection
+// to the server or the replica set's primary was lost, such that the
+// operation may succeed once a primary is available again.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, ErrLostConnection),
+		strings.Contains(msg, ErrNoReachableServers),
+		strings.Contains(msg, ErrNotMaster),
+		strings.Contains(msg, ErrConnectionRefusedSuffix),
+		strings.HasPrefix(msg, ErrCouldNotContactPrimaryPrefix),
+		strings.HasPrefix(msg, ErrCouldNotFindPrimaryPrefix),
+		strings.Contains(msg, "server selection error"),
+		strings.Contains(msg, "server selection timeout"):
+		return true
+	}
+	if cmdErr, ok := err.(mongo.CommandError); ok {
+		return cmdErr.HasErrorLabel("TransientTransactionError") || isNotMasterCode(cmdErr.Code)
+	}
+	return false
+}
+
+// isNotMasterCode returns whether the server error code is one of those sent
+// when a node is not, or is no longer, primary.
+func isNotMasterCode(code int32) bool {
+	switch code {
+	case 10107, // NotMaster
+		13435, // NotMasterNoSlaveOk
+		11600, // InterruptedAtShutdown
+		11602, // InterruptedDueToReplStateChange
+		189,   // PrimarySteppedDown
+		91:    // ShutdownInProgress
+		return true
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// flakyServer is a health check which fails while the server is down.
+type flakyServer struct {
+	down int32
+}
+
+func (s *flakyServer) setDown(down bool) {
+	var value int32
+	if down {
+		value = 1
+	}
+	atomic.StoreInt32(&s.down, value)
+}
+
+func (s *flakyServer) check(context.Context) error {
+	if atomic.LoadInt32(&s.down) == 1 {
+		return errors.New("server selection timeout")
+	}
+	return nil
+}
+
+func TestHealthMonitor(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a health monitor checking a server", t, func() {
+		server := &flakyServer{}
+		monitor := newHealthMonitor(server.check, 10*time.Millisecond, time.Second)
+		events := make(chan ConnectionEvent, 10)
+		monitor.Subscribe(func(event ConnectionEvent) { events <- event })
+		monitor.Start()
+		Reset(monitor.Stop)
+
+		Convey("a primary should initially be available", func() {
+			So(monitor.State(), ShouldEqual, Connected)
+			So(monitor.WaitForPrimary(context.Background()), ShouldBeNil)
+		})
+
+		Convey("losing the primary should be reported", func() {
+			server.setDown(true)
+			monitor.NotifyError(errors.New(ErrNotMaster))
+			event := <-events
+			So(event.Previous, ShouldEqual, Connected)
+			So(event.State, ShouldEqual, NoPrimary)
+			So(event.Err, ShouldNotBeNil)
+			So(event.String(), ShouldContainSubstring, "server selection timeout")
+
+			Convey("and callers should wait until it is available again", func() {
+				waited := make(chan error)
+				go func() { waited <- monitor.WaitForPrimary(context.Background()) }()
+
+				select {
+				case <-waited:
+					t.Fatal("WaitForPrimary returned while no primary was available")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				server.setDown(false)
+				So(<-waited, ShouldBeNil)
+				event := <-events
+				So(event.State, ShouldEqual, Connected)
+			})
+
+			Convey("and callers should be released when the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				So(monitor.WaitForPrimary(ctx), ShouldEqual, context.Canceled)
+			})
+
+			Convey("and callers should be released when the monitor stops", func() {
+				monitor.Stop()
+				So(monitor.WaitForPrimary(context.Background()), ShouldEqual, ErrMonitorStopped)
+				So((<-events).State, ShouldEqual, MonitorStopped)
+			})
+		})
+	})
+
+	Convey("Waiting for a primary should time out", t, func() {
+		server := &flakyServer{}
+		server.setDown(true)
+		monitor := newHealthMonitor(server.check, 10*time.Millisecond, 50*time.Millisecond)
+		monitor.Start()
+		defer monitor.Stop()
+		monitor.NotifyError(errors.New(ErrLostConnection))
+
+		for monitor.State() != NoPrimary {
+			time.Sleep(time.Millisecond)
+		}
+		err := monitor.WaitForPrimary(context.Background())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no primary available")
+	})
+}
+
+func TestSessionProviderRetries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a session provider monitoring a server", t, func() {
+		server := &flakyServer{}
+		monitor := newHealthMonitor(server.check, 10*time.Millisecond, time.Second)
+		events := make(chan ConnectionEvent, 10)
+		monitor.Subscribe(func(event ConnectionEvent) { events <- event })
+		monitor.Start()
+		sp := &SessionProvider{client: &mongo.Client{}, monitor: monitor}
+		Reset(monitor.Stop)
+
+		Convey("an operation failing because the primary stepped down should be retried once one is elected", func() {
+			attempts := 0
+			err := sp.withRetries(context.Background(), func(*mongo.Client) error {
+				attempts++
+				if attempts == 1 {
+					server.setDown(true)
+					time.AfterFunc(50*time.Millisecond, func() { server.setDown(false) })
+					return mongo.CommandError{Code: 189, Message: "PrimarySteppedDown"}
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 2)
+			So((<-events).State, ShouldEqual, NoPrimary)
+			So((<-events).State, ShouldEqual, Connected)
+		})
+
+		Convey("other errors should not be retried", func() {
+			attempts := 0
+			err := sp.withRetries(context.Background(), func(*mongo.Client) error {
+				attempts++
+				return mongo.CommandError{Code: 26, Message: "ns not found"}
+			})
+			So(err, ShouldNotBeNil)
+			So(attempts, ShouldEqual, 1)
+		})
+
+		Convey("an operation run without retries should only notify the monitor", func() {
+			attempts := 0
+			err := sp.withClient(context.Background(), func(*mongo.Client) error {
+				attempts++
+				server.setDown(true)
+				time.AfterFunc(50*time.Millisecond, func() { server.setDown(false) })
+				return mongo.CommandError{Code: 189, Message: "PrimarySteppedDown"}
+			})
+			So(IsConnectionError(err), ShouldBeTrue)
+			So(attempts, ShouldEqual, 1)
+			So((<-events).State, ShouldEqual, NoPrimary)
+		})
+
+		Convey("retries should stop if no primary is elected in time", func() {
+			monitor.timeout = 50 * time.Millisecond
+			attempts := 0
+			err := sp.withRetries(context.Background(), func(*mongo.Client) error {
+				attempts++
+				server.setDown(true)
+				return errors.New(ErrLostConnection)
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no primary available")
+			So(attempts, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Without a health monitor, operations should not be retried", t, func() {
+		sp := &SessionProvider{client: &mongo.Client{}}
+		attempts := 0
+		err := sp.withRetries(context.Background(), func(*mongo.Client) error {
+			attempts++
+			return errors.New(ErrLostConnection)
+		})
+		So(err, ShouldNotBeNil)
+		So(attempts, ShouldEqual, 1)
+	})
+}
+
+func TestIsConnectionError(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("IsConnectionError should recognize lost connections and primaries", t, func() {
+		So(IsConnectionError(nil), ShouldBeFalse)
+		So(IsConnectionError(errors.New("E11000 duplicate key error")), ShouldBeFalse)
+		So(IsConnectionError(errors.New(ErrLostConnection)), ShouldBeTrue)
+		So(IsConnectionError(errors.New("server selection error: context deadline exceeded")), ShouldBeTrue)
+		So(IsConnectionError(mongo.CommandError{Code: 189, Message: "stepped down"}), ShouldBeTrue)
+		So(IsConnectionError(mongo.CommandError{Code: 26, Message: "ns not found"}), ShouldBeFalse)
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -55,18 +56,30 @@ func (ci *CollectionInfo) GetUUID() string {
 // against system.indexes (pre-3.0 systems). nil is returned if the collection
 // does not exist.
 func GetIndexes(coll *mongo.Collection) (*mongo.Cursor, error) {
-	return coll.Indexes().List(context.Background())
+	return GetIndexesContext(signals.Context(), coll)
+}
+
+// GetIndexesContext is like GetIndexes but the command is interrupted if the
+// context is cancelled.
+func GetIndexesContext(ctx context.Context, coll *mongo.Collection) (*mongo.Cursor, error) {
+	return coll.Indexes().List(ctx)
 }
 
 // Assumes that mongo.Database will normalize legacy names to omit database
 // name as required by the Enumerate Collections spec
 func GetCollections(database *mongo.Database, name string) (*mongo.Cursor, error) {
+	return GetCollectionsContext(signals.Context(), database, name)
+}
+
+// GetCollectionsContext is like GetCollections but the command is interrupted
+// if the context is cancelled.
+func GetCollectionsContext(ctx context.Context, database *mongo.Database, name string) (*mongo.Cursor, error) {
 	filter := bson.D{}
 	if len(name) > 0 {
 		filter = append(filter, primitive.E{"name", name})
 	}
 
-	cursor, err := database.ListCollections(nil, filter)
+	cursor, err := database.ListCollections(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +88,13 @@ func GetCollections(database *mongo.Database, name string) (*mongo.Cursor, error
 }
 
 func GetCollectionInfo(coll *mongo.Collection) (*CollectionInfo, error) {
-	iter, err := GetCollections(coll.Database(), coll.Name())
+	return GetCollectionInfoContext(signals.Context(), coll)
+}
+
+// GetCollectionInfoContext is like GetCollectionInfo but the command is
+// interrupted if the context is cancelled.
+func GetCollectionInfoContext(ctx context.Context, coll *mongo.Collection) (*CollectionInfo, error) {
+	iter, err := GetCollectionsContext(ctx, coll.Database(), coll.Name())
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +102,7 @@ func GetCollectionInfo(coll *mongo.Collection) (*CollectionInfo, error) {
 	comparisonName := coll.Name()
 
 	var foundCollInfo *CollectionInfo
-	for iter.Next(nil) {
+	for iter.Next(ctx) {
 		collInfo := &CollectionInfo{}
 		err = iter.Decode(collInfo)
 		if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -46,13 +47,19 @@ var zeroTimestamp = primitive.Timestamp{}
 
 // GetOplogTailTime constructs an OplogTailTime
 func GetOplogTailTime(client *mongo.Client) (OplogTailTime, error) {
+	return GetOplogTailTimeContext(signals.Context(), client)
+}
+
+// GetOplogTailTimeContext is like GetOplogTailTime but the queries are
+// interrupted if the context is cancelled.
+func GetOplogTailTimeContext(ctx context.Context, client *mongo.Client) (OplogTailTime, error) {
 	// Check oldest active first to be sure it is less-than-or-equal to the
 	// latest visible.
-	oldestActive, err := GetOldestActiveTransactionTimestamp(client)
+	oldestActive, err := GetOldestActiveTransactionTimestampContext(ctx, client)
 	if err != nil {
 		return OplogTailTime{}, err
 	}
-	latestVisible, err := GetLatestVisibleOplogTimestamp(client)
+	latestVisible, err := GetLatestVisibleOplogTimestampContext(ctx, client)
 	if err != nil {
 		return OplogTailTime{}, err
 	}
@@ -67,11 +74,18 @@ func GetOplogTailTime(client *mongo.Client) (OplogTailTime, error) {
 // timestamp from the config.transactions table or else a zero-value
 // primitive.Timestamp.
 func GetOldestActiveTransactionTimestamp(client *mongo.Client) (primitive.Timestamp, error) {
+	return GetOldestActiveTransactionTimestampContext(signals.Context(), client)
+}
+
+// GetOldestActiveTransactionTimestampContext is like
+// GetOldestActiveTransactionTimestamp but the query is interrupted if the
+// context is cancelled.
+func GetOldestActiveTransactionTimestampContext(ctx context.Context, client *mongo.Client) (primitive.Timestamp, error) {
 	coll := client.Database("config").Collection("transactions", mopts.Collection().SetReadConcern(readconcern.Local()))
 	filter := bson.D{{"state", bson.D{{"$in", bson.A{"prepared", "inProgress"}}}}}
 	opts := mopts.FindOne().SetSort(bson.D{{"startOpTime", 1}})
 
-	result, err := coll.FindOne(context.Background(), filter, opts).DecodeBytes()
+	result, err := coll.FindOne(ctx, filter, opts).DecodeBytes()
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return primitive.Timestamp{}, nil
@@ -97,7 +111,13 @@ func GetOldestActiveTransactionTimestamp(client *mongo.Client) (primitive.Timest
 // have been storage-committed. See SERVER-30724 for a more detailed
 // description.
 func GetLatestVisibleOplogTimestamp(client *mongo.Client) (primitive.Timestamp, error) {
-	latestOpTime, err := GetLatestOplogTimestamp(client, bson.D{})
+	return GetLatestVisibleOplogTimestampContext(signals.Context(), client)
+}
+
+// GetLatestVisibleOplogTimestampContext is like GetLatestVisibleOplogTimestamp
+// but the queries are interrupted if the context is cancelled.
+func GetLatestVisibleOplogTimestampContext(ctx context.Context, client *mongo.Client) (primitive.Timestamp, error) {
+	latestOpTime, err := GetLatestOplogTimestampContext(ctx, client, bson.D{})
 	if err != nil {
 		return primitive.Timestamp{}, err
 	}
@@ -106,7 +126,7 @@ func GetLatestVisibleOplogTimestamp(client *mongo.Client) (primitive.Timestamp,
 	var confirmOp Oplog
 	opts := mopts.FindOne().SetOplogReplay(true)
 	coll := client.Database("local").Collection("oplog.rs")
-	res := coll.FindOne(context.Background(), bson.M{"ts": bson.M{"$gte": latestOpTime}}, opts)
+	res := coll.FindOne(ctx, bson.M{"ts": bson.M{"$gte": latestOpTime}}, opts)
 	if err := res.Err(); err != nil {
 		return primitive.Timestamp{}, err
 	}
@@ -132,10 +152,16 @@ func GetLatestVisibleOplogTimestamp(client *mongo.Client) (primitive.Timestamp,
 // no oplog record matches.  This method does not ensure that all prior oplog
 // entries are visible (i.e. have been storage-committed).
 func GetLatestOplogTimestamp(client *mongo.Client, query interface{}) (primitive.Timestamp, error) {
+	return GetLatestOplogTimestampContext(signals.Context(), client, query)
+}
+
+// GetLatestOplogTimestampContext is like GetLatestOplogTimestamp but the query
+// is interrupted if the context is cancelled.
+func GetLatestOplogTimestampContext(ctx context.Context, client *mongo.Client, query interface{}) (primitive.Timestamp, error) {
 	var record Oplog
 	opts := mopts.FindOne().SetProjection(bson.M{"ts": 1}).SetSort(bson.D{{"$natural", -1}})
 	coll := client.Database("local").Collection("oplog.rs")
-	res := coll.FindOne(context.Background(), query, opts)
+	res := coll.FindOne(ctx, query, opts)
 	if err := res.Err(); err != nil {
 		return primitive.Timestamp{}, err
 	}
@@ -0,0 +1,404 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/bsonutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errUnbufferedTxn is returned for the entries of transactions which can't be
+// applied as they are read, since they may still be aborted.
+var errUnbufferedTxn = errors.New("prepared and multi-entry transactions must be " +
+	"buffered with txn.Buffer and applied once committed")
+
+// Server error codes which mean a command was already applied.
+const (
+	errCodeNamespaceNotFound = 26
+	errCodeIndexNotFound     = 27
+	errCodeNamespaceExists   = 48
+)
+
+// NamespaceRewriter maps a source namespace to the namespace it should be
+// applied to, or returns false if entries on it should be skipped. Commands on
+// a whole database are passed the database's "db.$cmd" namespace.
+type NamespaceRewriter func(namespace string) (string, bool)
+
+// OplogApplier applies oplog entries as individual CRUD operations and
+// commands, for deployments where applyOps can't be used, e.g. a mongos or a
+// user without the privileges for it.
+//
+// Every entry is applied idempotently, so that replay can restart from an
+// earlier checkpoint after a crash: inserts and replacements are upserts,
+// deletes and updates of missing documents are no-ops, and commands whose
+// effect is already in place are ignored. Prepared and multi-entry
+// transactions are rejected, since their entries must be buffered until the
+// transaction commits; see txn.Buffer.
+type OplogApplier struct {
+	client  *mongo.Client
+	rewrite NamespaceRewriter
+	// uuids maps the source collection UUIDs to target namespaces, keyed by
+	// the UUID's bytes
+	uuids map[string]string
+}
+
+// NewOplogApplier returns an applier which applies entries to their own
+// namespaces on the client's deployment.
+func NewOplogApplier(client *mongo.Client) *OplogApplier {
+	return &OplogApplier{
+		client: client,
+		uuids:  make(map[string]string),
+	}
+}
+
+// SetNamespaceRewriter sets the function which maps source namespaces to
+// target namespaces. It isn't applied to namespaces found by UUID.
+func (a *OplogApplier) SetNamespaceRewriter(rewrite NamespaceRewriter) *OplogApplier {
+	a.rewrite = rewrite
+	return a
+}
+
+// MapUUID directs entries for the source collection with the given UUID to
+// the target namespace, regardless of the namespace in the entries. Renames
+// and drops of the collection update the mapping.
+func (a *OplogApplier) MapUUID(uuid primitive.Binary, namespace string) *OplogApplier {
+	a.uuids[string(uuid.Data)] = namespace
+	return a
+}
+
+// Apply applies a single oplog entry. Entries whose namespace is skipped by
+// the rewriter, and no-op entries, are ignored.
+func (a *OplogApplier) Apply(ctx context.Context, op *Oplog) error {
+	var err error
+	switch op.Operation {
+	case "n":
+		return nil
+	case "i", "u", "d":
+		err = a.applyCRUD(ctx, op)
+	case "c":
+		err = a.applyCommand(ctx, op)
+	default:
+		err = fmt.Errorf("unknown operation type '%v'", op.Operation)
+	}
+	if err != nil {
+		return fmt.Errorf("error applying oplog entry on %v at %v: %v", op.Namespace, formatTimestamp(op.Timestamp), err)
+	}
+	return nil
+}
+
+// resolve returns the target namespace of an entry on a collection.
+func (a *OplogApplier) resolve(namespace string, uuid *primitive.Binary) (string, bool) {
+	if uuid != nil {
+		if target, ok := a.uuids[string(uuid.Data)]; ok {
+			return target, true
+		}
+	}
+	return a.rewriteNamespace(namespace)
+}
+
+func (a *OplogApplier) rewriteNamespace(namespace string) (string, bool) {
+	if a.rewrite == nil {
+		return namespace, true
+	}
+	return a.rewrite(namespace)
+}
+
+func (a *OplogApplier) collection(namespace string) *mongo.Collection {
+	db, coll := splitNamespace(namespace)
+	return a.client.Database(db).Collection(coll)
+}
+
+func (a *OplogApplier) applyCRUD(ctx context.Context, op *Oplog) error {
+	if strings.HasSuffix(op.Namespace, ".system.indexes") && op.Operation == "i" {
+		return a.applyLegacyIndexInsert(ctx, op)
+	}
+	namespace, ok := a.resolve(op.Namespace, op.UI)
+	if !ok {
+		return nil
+	}
+	coll := a.collection(namespace)
+
+	switch op.Operation {
+	case "i":
+		id, err := bsonutil.FindValueByKey("_id", &op.Object)
+		if err != nil {
+			return fmt.Errorf("insert has no _id")
+		}
+		_, err = coll.ReplaceOne(ctx, bson.D{{"_id", id}}, op.Object, mopts.Replace().SetUpsert(true))
+		return err
+	case "u":
+		if op.Query == nil {
+			return fmt.Errorf("update has no o2 selector")
+		}
+		if !isUpdateDocument(op.Object) {
+			_, err := coll.ReplaceOne(ctx, op.Query, op.Object, mopts.Replace().SetUpsert(true))
+			return err
+		}
+		update, err := updateOperators(op.Object)
+		if err != nil {
+			return err
+		}
+		// an update of a missing document isn't an upsert, since a later
+		// entry must have deleted it
+		_, err = coll.UpdateOne(ctx, op.Query, update)
+		return err
+	default:
+		_, err := coll.DeleteOne(ctx, op.Object)
+		return err
+	}
+}
+
+// applyLegacyIndexInsert applies an insert into system.indexes, which is how
+// servers before 3.6 log index builds, as a createIndexes command.
+func (a *OplogApplier) applyLegacyIndexInsert(ctx context.Context, op *Oplog) error {
+	ns, _ := bsonutil.FindValueByKey("ns", &op.Object)
+	source, ok := ns.(string)
+	if !ok {
+		return fmt.Errorf("index insert has no ns")
+	}
+	namespace, ok := a.rewriteNamespace(source)
+	if !ok {
+		return nil
+	}
+	return a.createIndexes(ctx, namespace, []bson.D{op.Object})
+}
+
+func (a *OplogApplier) createIndexes(ctx context.Context, namespace string, specs []bson.D) error {
+	indexes := make(bson.A, len(specs))
+	for i, spec := range specs {
+		indexes[i] = withoutKeys(spec, "ns")
+	}
+	db, coll := splitNamespace(namespace)
+	return a.runCommand(ctx, db, bson.D{{"createIndexes", coll}, {"indexes", indexes}})
+}
+
+// isUpdateDocument returns whether the object of an update entry holds update
+// operators, rather than a replacement document.
+func isUpdateDocument(object bson.D) bool {
+	return len(object) > 0 && strings.HasPrefix(object[0].Key, "$")
+}
+
+// updateOperators strips the version field from an update entry's object.
+// Only the $v:1 operator format is supported.
+func updateOperators(object bson.D) (bson.D, error) {
+	update := make(bson.D, 0, len(object))
+	for _, elem := range object {
+		if elem.Key != "$v" {
+			update = append(update, elem)
+			continue
+		}
+		if version, _ := elem.Value.(int32); version > 1 {
+			return nil, fmt.Errorf("unsupported update format $v: %v", version)
+		}
+	}
+	return update, nil
+}
+
+func (a *OplogApplier) applyCommand(ctx context.Context, op *Oplog) error {
+	if len(op.Object) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	db, _ := splitNamespace(op.Namespace)
+	name := op.Object[0].Key
+
+	switch name {
+	case "applyOps":
+		if isTrue(op.Object, "prepare") || isTrue(op.Object, "partialTxn") {
+			return errUnbufferedTxn
+		}
+		var doc struct {
+			Ops []Oplog `bson:"applyOps"`
+		}
+		raw, err := bson.Marshal(op.Object)
+		if err == nil {
+			err = bson.Unmarshal(raw, &doc)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading applyOps: %v", err)
+		}
+		for i := range doc.Ops {
+			if err = a.Apply(ctx, &doc.Ops[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "renameCollection":
+		return a.applyRename(ctx, op)
+	case "dropDatabase":
+		target, ok := a.rewriteNamespace(db + ".$cmd")
+		if !ok {
+			return nil
+		}
+		targetDB, _ := splitNamespace(target)
+		for uuid, namespace := range a.uuids {
+			if mappedDB, _ := splitNamespace(namespace); mappedDB == targetDB {
+				delete(a.uuids, uuid)
+			}
+		}
+		return a.runCommand(ctx, targetDB, bson.D{{"dropDatabase", 1}})
+	case "commitTransaction", "abortTransaction":
+		// these only end prepared or multi-entry transactions
+		return errUnbufferedTxn
+	case "startIndexBuild", "abortIndexBuild":
+		// index builds are applied on commitIndexBuild
+		return nil
+	case "create", "drop", "collMod", "createIndexes", "commitIndexBuild",
+		"dropIndexes", "deleteIndexes", "convertToCapped", "emptycapped":
+	default:
+		return fmt.Errorf("unsupported command '%v'", name)
+	}
+
+	coll, ok := op.Object[0].Value.(string)
+	if !ok {
+		return fmt.Errorf("%v command has no collection name", name)
+	}
+	namespace, ok := a.resolve(db+"."+coll, op.UI)
+	if !ok {
+		return nil
+	}
+	targetDB, targetColl := splitNamespace(namespace)
+	cmd := append(bson.D{{name, targetColl}}, op.Object[1:]...)
+
+	switch name {
+	case "create":
+		if spec, err := bsonutil.FindSubdocumentByKey("idIndex", &cmd); err == nil {
+			cmd = setKey(cmd, "idIndex", withoutKeys(spec, "ns"))
+		}
+		if op.UI != nil {
+			a.uuids[string(op.UI.Data)] = namespace
+		}
+	case "drop":
+		if op.UI != nil {
+			delete(a.uuids, string(op.UI.Data))
+		}
+	case "createIndexes":
+		// the entry holds a single index spec in place of an indexes array
+		return a.createIndexes(ctx, namespace, []bson.D{withoutKeys(op.Object[1:], "ns")})
+	case "commitIndexBuild":
+		specs, err := indexSpecs(op.Object)
+		if err != nil {
+			return err
+		}
+		return a.createIndexes(ctx, namespace, specs)
+	}
+	return a.runCommand(ctx, targetDB, cmd)
+}
+
+func (a *OplogApplier) applyRename(ctx context.Context, op *Oplog) error {
+	from, _ := bsonutil.FindValueByKey("renameCollection", &op.Object)
+	to, _ := bsonutil.FindValueByKey("to", &op.Object)
+	fromNS, ok1 := from.(string)
+	toNS, ok2 := to.(string)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("renameCollection needs source and target namespaces")
+	}
+
+	source, sourceOK := a.resolve(fromNS, op.UI)
+	target, targetOK := a.rewriteNamespace(toNS)
+	if !sourceOK || !targetOK {
+		return nil
+	}
+	cmd := bson.D{{"renameCollection", source}, {"to", target}}
+	if dropTarget, err := bsonutil.FindValueByKey("dropTarget", &op.Object); err == nil {
+		// newer servers log the dropped collection's UUID instead of true
+		drop, isBool := dropTarget.(bool)
+		cmd = append(cmd, bson.E{"dropTarget", drop || !isBool})
+	}
+	err := a.runCommand(ctx, "admin", cmd)
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == errCodeNamespaceExists {
+		// the source's rename succeeded, so the target only exists here if an
+		// earlier replay already applied it, and replaying the entries before
+		// it recreated the source, which is dropped to finish the rename
+		sourceDB, sourceColl := splitNamespace(source)
+		err = a.runCommand(ctx, sourceDB, bson.D{{"drop", sourceColl}})
+	}
+	if err != nil {
+		return err
+	}
+	if op.UI != nil {
+		a.uuids[string(op.UI.Data)] = target
+	}
+	return nil
+}
+
+// isTrue returns whether the document holds the key with the value true.
+func isTrue(doc bson.D, key string) bool {
+	value, _ := bsonutil.FindValueByKey(key, &doc)
+	b, _ := value.(bool)
+	return b
+}
+
+// runCommand runs a command, ignoring errors which mean that its effect is
+// already in place.
+func (a *OplogApplier) runCommand(ctx context.Context, db string, cmd bson.D) error {
+	err := a.client.Database(db).RunCommand(ctx, cmd).Err()
+	if cmdErr, ok := err.(mongo.CommandError); ok {
+		switch {
+		case cmdErr.Code == errCodeNamespaceNotFound && cmd[0].Key != "createIndexes":
+			return nil
+		case cmdErr.Code == errCodeNamespaceExists && cmd[0].Key == "create":
+			return nil
+		case cmdErr.Code == errCodeIndexNotFound:
+			return nil
+		}
+	}
+	return err
+}
+
+// indexSpecs decodes the indexes array of a commitIndexBuild entry.
+func indexSpecs(object bson.D) ([]bson.D, error) {
+	var doc struct {
+		Indexes []bson.D `bson:"indexes"`
+	}
+	raw, err := bson.Marshal(object)
+	if err == nil {
+		err = bson.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index specs: %v", err)
+	}
+	return doc.Indexes, nil
+}
+
+func splitNamespace(namespace string) (string, string) {
+	i := strings.Index(namespace, ".")
+	if i < 0 {
+		return namespace, ""
+	}
+	return namespace[:i], namespace[i+1:]
+}
+
+func setKey(doc bson.D, key string, value interface{}) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+		}
+	}
+	return doc
+}
+
+func withoutKeys(doc bson.D, keys ...string) bson.D {
+	out := make(bson.D, 0, len(doc))
+outer:
+	for _, elem := range doc {
+		for _, key := range keys {
+			if elem.Key == key {
+				continue outer
+			}
+		}
+		out = append(out, elem)
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOplogApplierNamespaces(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an oplog applier", t, func() {
+		applier := NewOplogApplier(nil).SetNamespaceRewriter(func(ns string) (string, bool) {
+			if strings.HasPrefix(ns, "skip.") {
+				return "", false
+			}
+			return strings.Replace(ns, "src.", "dst.", 1), true
+		})
+		uuid := primitive.Binary{Subtype: 4, Data: []byte("0123456789abcdef")}
+
+		Convey("namespaces should be rewritten", func() {
+			ns, ok := applier.resolve("src.foo", nil)
+			So(ok, ShouldBeTrue)
+			So(ns, ShouldEqual, "dst.foo")
+
+			_, ok = applier.resolve("skip.foo", nil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("mapped UUIDs should take precedence over namespaces", func() {
+			applier.MapUUID(uuid, "other.bar")
+			ns, ok := applier.resolve("src.foo", &uuid)
+			So(ok, ShouldBeTrue)
+			So(ns, ShouldEqual, "other.bar")
+
+			unmapped := primitive.Binary{Subtype: 4, Data: []byte("fedcba9876543210")}
+			ns, _ = applier.resolve("src.foo", &unmapped)
+			So(ns, ShouldEqual, "dst.foo")
+		})
+
+		Convey("skipped entries should not be applied", func() {
+			err := applier.Apply(context.Background(), &Oplog{
+				Operation: "i",
+				Namespace: "skip.foo",
+				Object:    bson.D{{"_id", 1}},
+			})
+			So(err, ShouldBeNil)
+			So(applier.Apply(context.Background(), &Oplog{Operation: "n", Namespace: ""}), ShouldBeNil)
+		})
+
+		Convey("unknown operations should be reported", func() {
+			err := applier.Apply(context.Background(), &Oplog{Operation: "x", Namespace: "src.foo"})
+			So(err, ShouldNotBeNil)
+			err = applier.Apply(context.Background(), &Oplog{
+				Operation: "c",
+				Namespace: "src.$cmd",
+				Object:    bson.D{{"shardCollection", "src.foo"}},
+			})
+			So(err.Error(), ShouldContainSubstring, "unsupported command")
+		})
+	})
+
+	Convey("Transactions which may still be aborted should be rejected", t, func() {
+		applier := NewOplogApplier(nil)
+		for _, object := range []bson.D{
+			{{"applyOps", bson.A{}}, {"prepare", true}},
+			{{"applyOps", bson.A{}}, {"partialTxn", true}},
+			{{"commitTransaction", 1}, {"commitTimestamp", primitive.Timestamp{T: 1}}},
+			{{"abortTransaction", 1}},
+		} {
+			err := applier.Apply(context.Background(), &Oplog{Operation: "c", Namespace: "admin.$cmd", Object: object})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "txn.Buffer")
+		}
+	})
+
+	Convey("Update entries should be converted to updates", t, func() {
+		So(isUpdateDocument(bson.D{{"$v", 1}, {"$set", bson.D{{"a", 1}}}}), ShouldBeTrue)
+		So(isUpdateDocument(bson.D{{"_id", 1}, {"a", 1}}), ShouldBeFalse)
+
+		update, err := updateOperators(bson.D{{"$v", int32(1)}, {"$set", bson.D{{"a", 1}}}})
+		So(err, ShouldBeNil)
+		So(update, ShouldResemble, bson.D{{"$set", bson.D{{"a", 1}}}})
+
+		_, err = updateOperators(bson.D{{"$v", int32(2)}, {"diff", bson.D{}}})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestOplogApplier(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+
+	auth := DBGetAuthOptions()
+	ssl := DBGetSSLOptions()
+
+	Convey("With an oplog applier connected to a server", t, func() {
+		provider, err := NewSessionProvider(options.ToolOptions{
+			Connection: &options.Connection{Port: DefaultTestPort},
+			SSL:        &ssl,
+			Auth:       &auth,
+		})
+		So(err, ShouldBeNil)
+		client, err := provider.GetSession()
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+		database := client.Database("oplog_applier_test")
+		_ = database.Drop(ctx)
+		Reset(func() { _ = database.Drop(ctx) })
+
+		applier := NewOplogApplier(client).SetNamespaceRewriter(func(ns string) (string, bool) {
+			return strings.Replace(ns, "oplog_applier_src", "oplog_applier_test", 1), true
+		})
+		ops := []Oplog{
+			{Operation: "c", Namespace: "oplog_applier_src.$cmd", Object: bson.D{{"create", "foo"}}},
+			{Operation: "i", Namespace: "oplog_applier_src.foo", Object: bson.D{{"_id", 1}, {"a", 1}}},
+			{Operation: "i", Namespace: "oplog_applier_src.foo", Object: bson.D{{"_id", 2}, {"a", 2}}},
+			{Operation: "u", Namespace: "oplog_applier_src.foo",
+				Query: bson.D{{"_id", 1}}, Object: bson.D{{"$v", int32(1)}, {"$set", bson.D{{"a", 10}}}}},
+			{Operation: "d", Namespace: "oplog_applier_src.foo", Object: bson.D{{"_id", 2}}},
+			{Operation: "c", Namespace: "oplog_applier_src.$cmd",
+				Object: bson.D{{"createIndexes", "foo"}, {"v", 2}, {"key", bson.D{{"a", 1}}}, {"name", "a_1"}}},
+			{Operation: "c", Namespace: "oplog_applier_src.$cmd", Object: bson.D{
+				{"renameCollection", "oplog_applier_src.foo"},
+				{"to", "oplog_applier_src.bar"},
+			}},
+		}
+
+		Convey("entries should be applied to the rewritten namespaces, even twice", func() {
+			// replay everything before the rename again, as after a crash, then
+			// the rename itself
+			for round := 0; round < 2; round++ {
+				for i := range ops[:len(ops)-1] {
+					So(applier.Apply(ctx, &ops[i]), ShouldBeNil)
+				}
+			}
+			for round := 0; round < 2; round++ {
+				So(applier.Apply(ctx, &ops[len(ops)-1]), ShouldBeNil)
+			}
+
+			bar := database.Collection("bar")
+			count, err := bar.CountDocuments(ctx, bson.D{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			var doc bson.D
+			So(bar.FindOne(ctx, bson.D{{"_id", 1}}).Decode(&doc), ShouldBeNil)
+			So(doc, ShouldResemble, bson.D{{"_id", int32(1)}, {"a", int32(10)}})
+
+			indexes, err := GetIndexes(bar)
+			So(err, ShouldBeNil)
+			var names []string
+			for indexes.Next(ctx) {
+				var index struct {
+					Name string `bson:"name"`
+				}
+				So(indexes.Decode(&index), ShouldBeNil)
+				names = append(names, index.Name)
+			}
+			So(names, ShouldContain, "a_1")
+		})
+
+		Convey("replaying across the rename should not leave the source behind", func() {
+			for round := 0; round < 2; round++ {
+				for i := range ops {
+					So(applier.Apply(ctx, &ops[i]), ShouldBeNil)
+				}
+			}
+
+			names, err := database.ListCollectionNames(ctx, bson.D{})
+			So(err, ShouldBeNil)
+			So(names, ShouldContain, "bar")
+			So(names, ShouldNotContain, "foo")
+
+			count, err := database.Collection("bar").CountDocuments(ctx, bson.D{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
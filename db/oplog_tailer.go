@@ -0,0 +1,322 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultOplogMaxAwaitTime is how long the server waits for new oplog entries
+// before returning an empty batch to an OplogTailer.
+const DefaultOplogMaxAwaitTime = time.Second
+
+// OplogFellOffError is returned by an OplogTailer when the oplog no longer
+// contains the entry it would resume from, because it has been overwritten
+// since. Tailing can't continue without losing operations; the data has to be
+// synced again from scratch.
+type OplogFellOffError struct {
+	// Start is the timestamp the tailer needed to resume from.
+	Start primitive.Timestamp
+	// Oldest is the timestamp of the oldest entry in the oplog, if known.
+	Oldest primitive.Timestamp
+}
+
+func (e *OplogFellOffError) Error() string {
+	if e.Oldest == zeroTimestamp {
+		return fmt.Sprintf("fell off the oplog: entry at %v has been overwritten", formatTimestamp(e.Start))
+	}
+	return fmt.Sprintf("fell off the oplog: entry at %v has been overwritten; the oldest entry is at %v",
+		formatTimestamp(e.Start), formatTimestamp(e.Oldest))
+}
+
+func formatTimestamp(ts primitive.Timestamp) string {
+	return fmt.Sprintf("Timestamp(%d, %d)", ts.T, ts.I)
+}
+
+// IsOplogFellOffError returns whether the error means the tailer fell off the
+// oplog.
+func IsOplogFellOffError(err error) bool {
+	_, ok := err.(*OplogFellOffError)
+	return ok
+}
+
+// errCodeCappedPositionLost is the server error returned when a tailable
+// cursor's position in a capped collection has been overwritten.
+const errCodeCappedPositionLost = 136
+
+// OplogTailer reads local.oplog.rs with a tailable cursor, starting from a
+// timestamp or from its last checkpoint, and optionally filters the entries
+// by namespace and operation type.
+type OplogTailer struct {
+	oplog          *mongo.Collection
+	start          primitive.Timestamp
+	namespaces     []string
+	operations     map[string]bool
+	checkpointFile string
+	maxAwaitTime   time.Duration
+
+	cursor *mongo.Cursor
+	// last is the timestamp of the last entry read, filtered or not
+	last primitive.Timestamp
+	// fromCheckpoint is set if start was read from the checkpoint file, in
+	// which case the entry at start has already been applied
+	fromCheckpoint bool
+	current        Oplog
+	err            error
+}
+
+// NewOplogTailer returns a tailer which starts at the entry with the given
+// timestamp, inclusive. A zero timestamp starts at the oldest entry.
+func NewOplogTailer(client *mongo.Client, start primitive.Timestamp) *OplogTailer {
+	return &OplogTailer{
+		oplog:        client.Database("local").Collection("oplog.rs"),
+		start:        start,
+		maxAwaitTime: DefaultOplogMaxAwaitTime,
+	}
+}
+
+// SetNamespaces restricts the tailer to entries on the given namespaces, each
+// of which is either a database name or a full "db.collection" namespace.
+// Commands on a database match any of its namespaces, and transactions match
+// if any of their operations do.
+func (t *OplogTailer) SetNamespaces(namespaces ...string) *OplogTailer {
+	t.namespaces = namespaces
+	return t
+}
+
+// SetOperations restricts the tailer to entries with the given operation
+// types, e.g. "i", "u", "d", "c" or "n".
+func (t *OplogTailer) SetOperations(operations ...string) *OplogTailer {
+	t.operations = make(map[string]bool, len(operations))
+	for _, op := range operations {
+		t.operations[op] = true
+	}
+	return t
+}
+
+// SetMaxAwaitTime sets how long the server waits for new entries before
+// returning an empty batch.
+func (t *OplogTailer) SetMaxAwaitTime(d time.Duration) *OplogTailer {
+	t.maxAwaitTime = d
+	return t
+}
+
+// SetCheckpointFile sets the file in which Checkpoint records the last
+// applied timestamp. If the file already holds a checkpoint, tailing resumes
+// after it instead of at the start timestamp.
+func (t *OplogTailer) SetCheckpointFile(path string) (*OplogTailer, error) {
+	t.checkpointFile = path
+	ts, err := ReadOplogCheckpoint(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.start = ts
+	t.fromCheckpoint = true
+	return t, nil
+}
+
+// Next advances to the next matching oplog entry, blocking until one is
+// available. It returns false when the context is done or an error occurs,
+// in which case Err returns the error; an *OplogFellOffError means the tailer
+// can't continue.
+func (t *OplogTailer) Next(ctx context.Context) bool {
+	for t.err == nil {
+		if t.cursor == nil {
+			if t.err = t.openCursor(ctx); t.err != nil {
+				return false
+			}
+		}
+		if !t.cursor.Next(ctx) {
+			t.err = t.cursorError(ctx)
+			_ = t.cursor.Close(context.Background())
+			t.cursor = nil
+			continue
+		}
+
+		var op Oplog
+		if t.err = t.cursor.Decode(&op); t.err != nil {
+			t.err = fmt.Errorf("error decoding oplog entry: %v", t.err)
+			return false
+		}
+		t.last = op.Timestamp
+		if t.matches(&op) {
+			t.current = op
+			return true
+		}
+	}
+	return false
+}
+
+// openCursor checks that the oplog still holds the entry to resume from, and
+// opens a tailable cursor on the oplog starting at it.
+func (t *OplogTailer) openCursor(ctx context.Context) error {
+	from, inclusive := t.start, !t.fromCheckpoint
+	if t.last != zeroTimestamp {
+		from, inclusive = t.last, false
+	}
+
+	if from != zeroTimestamp {
+		var oldest Oplog
+		opts := mopts.FindOne().SetProjection(bson.M{"ts": 1}).SetSort(bson.D{{"$natural", 1}})
+		err := t.oplog.FindOne(ctx, bson.D{}, opts).Decode(&oldest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("error finding the oldest oplog entry: %v", err)
+		}
+		if err == nil && util.TimestampGreaterThan(oldest.Timestamp, from) {
+			return &OplogFellOffError{Start: from, Oldest: oldest.Timestamp}
+		}
+	}
+
+	operator := "$gt"
+	if inclusive {
+		operator = "$gte"
+	}
+	opts := mopts.Find().
+		SetCursorType(mopts.TailableAwait).
+		SetOplogReplay(true).
+		SetNoCursorTimeout(true).
+		SetMaxAwaitTime(t.maxAwaitTime)
+	cursor, err := t.oplog.Find(ctx, bson.D{{"ts", bson.D{{operator, from}}}}, opts)
+	if err != nil {
+		return fmt.Errorf("error querying the oplog: %v", err)
+	}
+	t.cursor = cursor
+	return nil
+}
+
+// cursorError determines why the cursor stopped. It returns nil if the
+// cursor merely died, e.g. because the oplog was empty, and can be reopened.
+func (t *OplogTailer) cursorError(ctx context.Context) error {
+	err := t.cursor.Err()
+	if err == nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// back off before reopening a dead cursor
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.maxAwaitTime):
+		}
+		return nil
+	}
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == errCodeCappedPositionLost {
+		return &OplogFellOffError{Start: t.last}
+	}
+	return fmt.Errorf("error tailing the oplog: %v", err)
+}
+
+// matches returns whether the entry passes the tailer's filters. An applyOps
+// entry, e.g. a transaction, passes if any of its operations do.
+func (t *OplogTailer) matches(op *Oplog) bool {
+	if len(t.operations) == 0 && len(t.namespaces) == 0 {
+		return true
+	}
+	if op.Operation == "c" && len(op.Object) > 0 && op.Object[0].Key == "applyOps" {
+		var doc struct {
+			Ops []Oplog `bson:"applyOps"`
+		}
+		if raw, err := bson.Marshal(op.Object); err == nil && bson.Unmarshal(raw, &doc) == nil {
+			for i := range doc.Ops {
+				if t.matches(&doc.Ops[i]) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if len(t.operations) > 0 && !t.operations[op.Operation] {
+		return false
+	}
+	return len(t.namespaces) == 0 || t.matchesNamespace(op.Namespace)
+}
+
+func (t *OplogTailer) matchesNamespace(ns string) bool {
+	db := ns
+	if i := strings.Index(ns, "."); i >= 0 {
+		db = ns[:i]
+	}
+	isCommand := strings.HasSuffix(ns, ".$cmd")
+	for _, filter := range t.namespaces {
+		if filter == ns || filter == db || (isCommand && strings.HasPrefix(filter, db+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Oplog returns the entry read by the last call to Next.
+func (t *OplogTailer) Oplog() *Oplog {
+	return &t.current
+}
+
+// Err returns the error which stopped the tailer, if any.
+func (t *OplogTailer) Err() error {
+	return t.err
+}
+
+// Checkpoint durably records ts as the timestamp of the last applied entry,
+// so that a tailer using the same checkpoint file resumes after it. It is a
+// no-op if no checkpoint file is set.
+func (t *OplogTailer) Checkpoint(ts primitive.Timestamp) error {
+	if t.checkpointFile == "" {
+		return nil
+	}
+	return WriteOplogCheckpoint(t.checkpointFile, ts)
+}
+
+// Close closes the tailer's cursor.
+func (t *OplogTailer) Close(ctx context.Context) error {
+	if t.cursor == nil {
+		return nil
+	}
+	err := t.cursor.Close(ctx)
+	t.cursor = nil
+	return err
+}
+
+// ReadOplogCheckpoint reads a timestamp written by WriteOplogCheckpoint.
+func ReadOplogCheckpoint(path string) (primitive.Timestamp, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return primitive.Timestamp{}, err
+	}
+	var checkpoint struct {
+		Timestamp primitive.Timestamp `bson:"ts"`
+	}
+	if err = bson.UnmarshalExtJSON(data, true, &checkpoint); err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("error parsing oplog checkpoint file %v: %v", path, err)
+	}
+	return checkpoint.Timestamp, nil
+}
+
+// WriteOplogCheckpoint writes a timestamp to a file as canonical extended
+// JSON. The file is replaced atomically.
+func WriteOplogCheckpoint(path string, ts primitive.Timestamp) error {
+	data, err := bson.MarshalExtJSON(bson.D{{"ts", ts}}, true, false)
+	if err != nil {
+		return fmt.Errorf("error serializing oplog checkpoint: %v", err)
+	}
+	if err = writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing oplog checkpoint file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOplogTailerFilter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	insert := &Oplog{Operation: "i", Namespace: "test.foo"}
+	update := &Oplog{Operation: "u", Namespace: "test.bar"}
+	drop := &Oplog{Operation: "c", Namespace: "test.$cmd", Object: bson.D{{"drop", "foo"}}}
+	txn := &Oplog{
+		Operation: "c",
+		Namespace: "admin.$cmd",
+		Object: bson.D{{"applyOps", bson.A{
+			bson.D{{"op", "i"}, {"ns", "other.baz"}, {"o", bson.D{{"_id", 1}}}},
+			bson.D{{"op", "u"}, {"ns", "test.bar"}, {"o", bson.D{{"_id", 1}}}},
+		}}},
+	}
+
+	Convey("With an oplog tailer", t, func() {
+		tailer := &OplogTailer{}
+
+		Convey("every entry should match without filters", func() {
+			So(tailer.matches(insert), ShouldBeTrue)
+			So(tailer.matches(txn), ShouldBeTrue)
+		})
+
+		Convey("entries should be filtered by collection namespace", func() {
+			tailer.SetNamespaces("test.foo")
+			So(tailer.matches(insert), ShouldBeTrue)
+			So(tailer.matches(update), ShouldBeFalse)
+			So(tailer.matches(drop), ShouldBeTrue)
+			So(tailer.matches(txn), ShouldBeFalse)
+		})
+
+		Convey("entries should be filtered by database", func() {
+			tailer.SetNamespaces("other")
+			So(tailer.matches(insert), ShouldBeFalse)
+			So(tailer.matches(drop), ShouldBeFalse)
+			So(tailer.matches(txn), ShouldBeTrue)
+		})
+
+		Convey("entries should be filtered by operation type", func() {
+			tailer.SetOperations("u")
+			So(tailer.matches(insert), ShouldBeFalse)
+			So(tailer.matches(update), ShouldBeTrue)
+			So(tailer.matches(drop), ShouldBeFalse)
+			So(tailer.matches(txn), ShouldBeTrue)
+
+			tailer.SetNamespaces("other")
+			So(tailer.matches(txn), ShouldBeFalse)
+		})
+	})
+}
+
+func TestOplogCheckpoint(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Oplog checkpoints should be persisted to a file", t, func() {
+		dir, err := ioutil.TempDir("", "oplog_tailer_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "checkpoint.json")
+
+		_, err = ReadOplogCheckpoint(path)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		ts := primitive.Timestamp{T: 1500000000, I: 7}
+		So(WriteOplogCheckpoint(path, ts), ShouldBeNil)
+		read, err := ReadOplogCheckpoint(path)
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, ts)
+
+		// the temporary file should have been renamed over the checkpoint
+		entries, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(syncDir(filepath.Join(dir, "missing")), ShouldNotBeNil)
+
+		Convey("and a tailer should resume after the checkpoint", func() {
+			tailer, err := (&OplogTailer{}).SetCheckpointFile(path)
+			So(err, ShouldBeNil)
+			So(tailer.start, ShouldResemble, ts)
+			So(tailer.fromCheckpoint, ShouldBeTrue)
+
+			So(tailer.Checkpoint(primitive.Timestamp{T: 1500000001}), ShouldBeNil)
+			read, err := ReadOplogCheckpoint(path)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, primitive.Timestamp{T: 1500000001})
+		})
+	})
+
+	Convey("Falling off the oplog should be recognizable", t, func() {
+		err := error(&OplogFellOffError{Start: primitive.Timestamp{T: 1, I: 1}, Oldest: primitive.Timestamp{T: 2, I: 1}})
+		So(IsOplogFellOffError(err), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "Timestamp(1, 1)")
+		So(err.Error(), ShouldContainSubstring, "Timestamp(2, 1)")
+	})
+}
+
+func TestOplogTailer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+
+	auth := DBGetAuthOptions()
+	ssl := DBGetSSLOptions()
+
+	Convey("With a connection to a replica set", t, func() {
+		provider, err := NewSessionProvider(options.ToolOptions{
+			Connection: &options.Connection{Port: DefaultTestPort},
+			SSL:        &ssl,
+			Auth:       &auth,
+		})
+		So(err, ShouldBeNil)
+		if ok, _ := provider.IsReplicaSet(); !ok {
+			SkipConvey("tailing the oplog requires a replica set", func() {})
+			return
+		}
+		client, err := provider.GetSession()
+		So(err, ShouldBeNil)
+		coll := client.Database("oplog_tailer_test").Collection("foo")
+		_ = coll.Drop(context.Background())
+		Reset(func() { _ = coll.Drop(context.Background()) })
+
+		start, err := GetLatestOplogTimestamp(client, bson.D{})
+		So(err, ShouldBeNil)
+
+		Convey("new entries should be tailed from the start timestamp", func() {
+			_, err = coll.InsertOne(context.Background(), bson.D{{"_id", 1}})
+			So(err, ShouldBeNil)
+			_, err = coll.InsertOne(context.Background(), bson.D{{"_id", 2}})
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			tailer := NewOplogTailer(client, start).
+				SetNamespaces("oplog_tailer_test.foo").
+				SetOperations("i").
+				SetMaxAwaitTime(100 * time.Millisecond)
+			defer tailer.Close(ctx)
+
+			So(tailer.Next(ctx), ShouldBeTrue)
+			So(tailer.Oplog().Object, ShouldResemble, bson.D{{"_id", int32(1)}})
+			So(tailer.Next(ctx), ShouldBeTrue)
+			So(tailer.Oplog().Object, ShouldResemble, bson.D{{"_id", int32(2)}})
+			So(tailer.Err(), ShouldBeNil)
+		})
+
+		Convey("a timestamp before the oldest entry should be reported", func() {
+			tailer := NewOplogTailer(client, primitive.Timestamp{T: 1, I: 1})
+			So(tailer.Next(context.Background()), ShouldBeFalse)
+			So(IsOplogFellOffError(tailer.Err()), ShouldBeTrue)
+		})
+	})
+}
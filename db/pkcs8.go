@@ -0,0 +1,189 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// errIncorrectKeyPassword is returned when an encrypted private key cannot be
+// decrypted with the given password.
+var errIncorrectKeyPassword = errors.New("incorrect password for encrypted private key")
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+)
+
+// pbkdf2PRFs maps the PBKDF2 pseudorandom function OIDs to their hashes.
+var pbkdf2PRFs = []struct {
+	oid  asn1.ObjectIdentifier
+	hash func() hash.Hash
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}, sha1.New},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}, sha256.New224},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}, sha256.New},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}, sha512.New384},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}, sha512.New},
+}
+
+// pbes2Ciphers maps the PBES2 encryption scheme OIDs to their block ciphers.
+var pbes2Ciphers = []struct {
+	oid     asn1.ObjectIdentifier
+	keySize int
+	create  func(key []byte) (cipher.Block, error)
+}{
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}, 16, aes.NewCipher},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}, 24, aes.NewCipher},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}, 32, aes.NewCipher},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}, 24, des.NewTripleDESCipher},
+}
+
+// encryptedPrivateKeyInfo is the PKCS#8 structure of an encrypted key.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts the DER contents of an "ENCRYPTED PRIVATE KEY" PEM
+// block and returns the DER encoding of the unencrypted PKCS#8 key. Only keys
+// encrypted with PBES2 are supported.
+func decryptPKCS8(der []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("private key is encrypted with PKCS#8 but no password was given")
+	}
+
+	var info encryptedPrivateKeyInfo
+	if err := unmarshalDER(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#8 encrypted private key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported PKCS#8 encryption algorithm %v, only PBES2 is supported",
+			info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if err := unmarshalDER(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported PBES2 key derivation function %v, only PBKDF2 is supported",
+			params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if err := unmarshalDER(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+
+	prf := sha1.New
+	if len(kdfParams.PRF.Algorithm) > 0 {
+		prf = nil
+		for _, p := range pbkdf2PRFs {
+			if p.oid.Equal(kdfParams.PRF.Algorithm) {
+				prf = p.hash
+			}
+		}
+		if prf == nil {
+			return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %v", kdfParams.PRF.Algorithm)
+		}
+	}
+
+	keySize := 0
+	var create func(key []byte) (cipher.Block, error)
+	for _, c := range pbes2Ciphers {
+		if c.oid.Equal(params.EncryptionScheme.Algorithm) {
+			keySize, create = c.keySize, c.create
+		}
+	}
+	if create == nil {
+		return nil, fmt.Errorf("unsupported PBES2 encryption scheme %v", params.EncryptionScheme.Algorithm)
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keySize {
+		return nil, fmt.Errorf("invalid PBKDF2 key length %v for encryption scheme %v",
+			kdfParams.KeyLength, params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if err := unmarshalDER(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 encryption scheme parameters: %v", err)
+	}
+
+	key := pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.IterationCount, keySize, prf)
+	block, err := create(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid PBES2 initialization vector length %v", len(iv))
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid PKCS#8 encrypted private key: data is not a multiple of the block size")
+	}
+
+	decrypted := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+
+	// an incorrect password is almost always detected by invalid padding,
+	// and otherwise by the result not being a private key
+	decrypted, ok := unpad(decrypted, block.BlockSize())
+	if !ok {
+		return nil, errIncorrectKeyPassword
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(decrypted); err != nil {
+		return nil, errIncorrectKeyPassword
+	}
+	return decrypted, nil
+}
+
+// unmarshalDER parses a single DER value, rejecting trailing data.
+func unmarshalDER(der []byte, out interface{}) error {
+	rest, err := asn1.Unmarshal(der, out)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("trailing data after ASN.1 value")
+	}
+	return nil
+}
+
+// unpad removes PKCS#7 padding, reporting whether it was valid.
+func unpad(data []byte, blockSize int) ([]byte, bool) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) {
+		return nil, false
+	}
+	if !bytes.Equal(data[len(data)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, false
+	}
+	return data[:len(data)-n], true
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"context"
+
+	"github.com/mongodb/mongo-tools-common/signals"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mopt "go.mongodb.org/mongo-driver/mongo/options"
@@ -16,13 +19,25 @@ type DeferredQuery struct {
 
 // EstimatedDocumentCount issues a count command.
 func (q *DeferredQuery) EstimatedDocumentCount() (int, error) {
+	return q.EstimatedDocumentCountContext(signals.Context())
+}
+
+// EstimatedDocumentCountContext is like EstimatedDocumentCount but the command
+// is interrupted if the context is cancelled.
+func (q *DeferredQuery) EstimatedDocumentCountContext(ctx context.Context) (int, error) {
 	opt := mopt.EstimatedDocumentCount()
-	c, err := q.Coll.EstimatedDocumentCount(nil, opt)
+	c, err := q.Coll.EstimatedDocumentCount(ctx, opt)
 	return int(c), err
 }
 
 // Iter executes a find query and returns a cursor.
 func (q *DeferredQuery) Iter() (*mongo.Cursor, error) {
+	return q.IterContext(signals.Context())
+}
+
+// IterContext is like Iter but the query is interrupted if the context is
+// cancelled. The cursor's later batches use the context passed to its Next.
+func (q *DeferredQuery) IterContext(ctx context.Context) (*mongo.Cursor, error) {
 	opts := mopt.Find()
 	if q.Hint != nil {
 		opts.SetHint(q.Hint)
@@ -34,5 +49,5 @@ func (q *DeferredQuery) Iter() (*mongo.Cursor, error) {
 	if filter == nil {
 		filter = bson.D{}
 	}
-	return q.Coll.Find(nil, filter, opts)
+	return q.Coll.Find(ctx, filter, opts)
 }
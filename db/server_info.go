@@ -0,0 +1,250 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/signals"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Feature is a server capability which tools may depend on.
+type Feature string
+
+// Features reported by ServerInfo.
+const (
+	FeatureTransactions    Feature = "transactions"
+	FeatureRetryableWrites Feature = "retryableWrites"
+	FeatureChangeStreams   Feature = "changeStreams"
+)
+
+// Wire versions of the server releases which introduced features.
+const (
+	wireVersion36 = 6
+	wireVersion40 = 7
+	wireVersion42 = 8
+)
+
+// ServerInfo is a snapshot of the connected server's topology, version and
+// capabilities.
+type ServerInfo struct {
+	NodeType NodeType
+	// SetName is the replica set name, if the server is part of one.
+	SetName string
+	// IsPrimary is true if the server accepts writes.
+	IsPrimary      bool
+	MaxWireVersion int
+	// LogicalSessionTimeoutMinutes is zero if the server does not support
+	// sessions.
+	LogicalSessionTimeoutMinutes int
+
+	Version       Version
+	VersionString string
+	// FCV is the featureCompatibilityVersion, or empty if it could not be
+	// determined, e.g. for lack of privileges.
+	FCV string
+	// StorageEngine is the name of the storage engine, or empty if it could
+	// not be determined, e.g. on a mongos.
+	StorageEngine string
+
+	Features  map[Feature]bool
+	FetchedAt time.Time
+}
+
+// Supports returns whether the server supports the feature.
+func (info *ServerInfo) Supports(feature Feature) bool {
+	return info.Features[feature]
+}
+
+// IsMMAPV1 returns whether the server uses the MMAPv1 storage engine.
+func (info *ServerInfo) IsMMAPV1() bool {
+	return info.StorageEngine == "mmapv1"
+}
+
+// ServerInfo returns the cached snapshot of the server's information,
+// fetching it on first use.
+func (sp *SessionProvider) ServerInfo() (*ServerInfo, error) {
+	return sp.ServerInfoContext(signals.Context())
+}
+
+// ServerInfoContext is like ServerInfo but the commands are interrupted if the
+// context is cancelled.
+func (sp *SessionProvider) ServerInfoContext(ctx context.Context) (*ServerInfo, error) {
+	sp.infoLock.Lock()
+	defer sp.infoLock.Unlock()
+	if sp.serverInfo != nil {
+		return sp.serverInfo, nil
+	}
+	return sp.refreshServerInfo(ctx)
+}
+
+// RefreshServerInfo fetches a new snapshot of the server's information and
+// caches it, e.g. after a failover or an upgrade.
+func (sp *SessionProvider) RefreshServerInfo() (*ServerInfo, error) {
+	return sp.RefreshServerInfoContext(signals.Context())
+}
+
+// RefreshServerInfoContext is like RefreshServerInfo but the commands are
+// interrupted if the context is cancelled.
+func (sp *SessionProvider) RefreshServerInfoContext(ctx context.Context) (*ServerInfo, error) {
+	sp.infoLock.Lock()
+	defer sp.infoLock.Unlock()
+	return sp.refreshServerInfo(ctx)
+}
+
+func (sp *SessionProvider) refreshServerInfo(ctx context.Context) (*ServerInfo, error) {
+	var info *ServerInfo
+	err := sp.withRetries(ctx, func(client *mongo.Client) (err error) {
+		info, err = fetchServerInfo(ctx, client.Database("admin"))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sp.serverInfo = info
+	return info, nil
+}
+
+// fetchServerInfo runs the commands which make up a ServerInfo. Only isMaster
+// and buildInfo are required; the FCV and storage engine are left empty if
+// they can't be read.
+func fetchServerInfo(ctx context.Context, admin *mongo.Database) (*ServerInfo, error) {
+	info := &ServerInfo{FetchedAt: time.Now()}
+
+	isMaster := struct {
+		IsMaster                     bool        `bson:"ismaster"`
+		SetName                      string      `bson:"setName"`
+		Hosts                        interface{} `bson:"hosts"`
+		Msg                          string      `bson:"msg"`
+		MaxWireVersion               int         `bson:"maxWireVersion"`
+		LogicalSessionTimeoutMinutes *int        `bson:"logicalSessionTimeoutMinutes"`
+	}{}
+	if err := admin.RunCommand(ctx, bson.D{{"isMaster", 1}}).Decode(&isMaster); err != nil {
+		return nil, fmt.Errorf("error running isMaster: %v", err)
+	}
+	switch {
+	case isMaster.SetName != "" || isMaster.Hosts != nil:
+		info.NodeType = ReplSet
+	case isMaster.Msg == "isdbgrid":
+		info.NodeType = Mongos
+	default:
+		info.NodeType = Standalone
+	}
+	info.SetName = isMaster.SetName
+	info.IsPrimary = isMaster.IsMaster
+	info.MaxWireVersion = isMaster.MaxWireVersion
+	if isMaster.LogicalSessionTimeoutMinutes != nil {
+		info.LogicalSessionTimeoutMinutes = *isMaster.LogicalSessionTimeoutMinutes
+	}
+
+	buildInfo := struct {
+		Version      string  `bson:"version"`
+		VersionArray []int32 `bson:"versionArray"`
+	}{}
+	if err := admin.RunCommand(ctx, bson.D{{"buildInfo", 1}}).Decode(&buildInfo); err != nil {
+		return nil, fmt.Errorf("error running buildInfo: %v", err)
+	}
+	if len(buildInfo.VersionArray) < len(info.Version) {
+		return nil, fmt.Errorf("buildInfo.versionArray had fewer than %d elements", len(info.Version))
+	}
+	info.VersionString = buildInfo.Version
+	for i := range info.Version {
+		info.Version[i] = int(buildInfo.VersionArray[i])
+	}
+
+	fcv := struct {
+		FCV bson.RawValue `bson:"featureCompatibilityVersion"`
+	}{}
+	err := admin.RunCommand(ctx, bson.D{{"getParameter", 1}, {"featureCompatibilityVersion", 1}}).Decode(&fcv)
+	if err != nil {
+		log.Logvf(log.DebugLow, "could not determine the featureCompatibilityVersion: %v", err)
+	} else {
+		info.FCV = parseFCV(fcv.FCV)
+	}
+
+	if info.NodeType != Mongos {
+		status := struct {
+			StorageEngine struct {
+				Name string `bson:"name"`
+			} `bson:"storageEngine"`
+		}{}
+		err = admin.RunCommand(ctx, bson.D{{"serverStatus", 1}}).Decode(&status)
+		if err != nil {
+			log.Logvf(log.DebugLow, "could not determine the storage engine: %v", err)
+		} else {
+			info.StorageEngine = status.StorageEngine.Name
+		}
+	}
+
+	info.Features = computeFeatures(info)
+	return info, nil
+}
+
+// parseFCV extracts the version from a featureCompatibilityVersion parameter,
+// which is a string before 3.6 and a document with a version field after.
+func parseFCV(value bson.RawValue) string {
+	if s, ok := value.StringValueOK(); ok {
+		return s
+	}
+	if doc, ok := value.DocumentOK(); ok {
+		if version, err := doc.LookupErr("version"); err == nil {
+			s, _ := version.StringValueOK()
+			return s
+		}
+	}
+	return ""
+}
+
+// computeFeatures determines the features which the server supports. A
+// feature is assumed to be unaffected by an FCV or storage engine which could
+// not be determined.
+func computeFeatures(info *ServerInfo) map[Feature]bool {
+	sessions := info.LogicalSessionTimeoutMinutes > 0
+	replicated := info.NodeType == ReplSet || info.NodeType == Mongos
+	majorityReads := !info.IsMMAPV1()
+
+	transactionsWireVersion, transactionsFCV := wireVersion40, "4.0"
+	if info.NodeType == Mongos {
+		transactionsWireVersion, transactionsFCV = wireVersion42, "4.2"
+	}
+
+	return map[Feature]bool{
+		FeatureRetryableWrites: replicated && sessions && majorityReads &&
+			info.MaxWireVersion >= wireVersion36,
+		FeatureChangeStreams: replicated && majorityReads &&
+			info.MaxWireVersion >= wireVersion36 && fcvAtLeast(info.FCV, "3.6"),
+		FeatureTransactions: replicated && sessions && majorityReads &&
+			info.MaxWireVersion >= transactionsWireVersion && fcvAtLeast(info.FCV, transactionsFCV),
+	}
+}
+
+// fcvAtLeast compares a featureCompatibilityVersion with a "major.minor"
+// version. An unknown FCV is taken to be high enough.
+func fcvAtLeast(fcv, min string) bool {
+	if fcv == "" {
+		return true
+	}
+	parse := func(v string) (int, int) {
+		parts := strings.SplitN(v, ".", 3)
+		major, _ := strconv.Atoi(parts[0])
+		minor := 0
+		if len(parts) > 1 {
+			minor, _ = strconv.Atoi(parts[1])
+		}
+		return major, minor
+	}
+	major, minor := parse(fcv)
+	minMajor, minMinor := parse(min)
+	return major > minMajor || (major == minMajor && minor >= minMinor)
+}
@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestComputeFeatures(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Features should be computed from the server's information", t, func() {
+		replSet := func(wire int, fcv, engine string) *ServerInfo {
+			return &ServerInfo{
+				NodeType:                     ReplSet,
+				MaxWireVersion:               wire,
+				LogicalSessionTimeoutMinutes: 30,
+				FCV:                          fcv,
+				StorageEngine:                engine,
+			}
+		}
+
+		Convey("a 4.0 replica set should support everything", func() {
+			info := replSet(wireVersion40, "4.0", "wiredTiger")
+			info.Features = computeFeatures(info)
+			So(info.Supports(FeatureTransactions), ShouldBeTrue)
+			So(info.Supports(FeatureRetryableWrites), ShouldBeTrue)
+			So(info.Supports(FeatureChangeStreams), ShouldBeTrue)
+		})
+
+		Convey("a 4.0 replica set with FCV 3.6 should not support transactions", func() {
+			info := replSet(wireVersion40, "3.6", "wiredTiger")
+			info.Features = computeFeatures(info)
+			So(info.Supports(FeatureTransactions), ShouldBeFalse)
+			So(info.Supports(FeatureChangeStreams), ShouldBeTrue)
+		})
+
+		Convey("an MMAPv1 replica set should support none of them", func() {
+			info := replSet(wireVersion40, "4.0", "mmapv1")
+			info.Features = computeFeatures(info)
+			So(info.IsMMAPV1(), ShouldBeTrue)
+			So(info.Supports(FeatureTransactions), ShouldBeFalse)
+			So(info.Supports(FeatureRetryableWrites), ShouldBeFalse)
+			So(info.Supports(FeatureChangeStreams), ShouldBeFalse)
+		})
+
+		Convey("a 4.0 mongos should not support transactions", func() {
+			info := replSet(wireVersion40, "", "")
+			info.NodeType = Mongos
+			info.Features = computeFeatures(info)
+			So(info.Supports(FeatureTransactions), ShouldBeFalse)
+			So(info.Supports(FeatureRetryableWrites), ShouldBeTrue)
+
+			info.MaxWireVersion = wireVersion42
+			info.Features = computeFeatures(info)
+			So(info.Supports(FeatureTransactions), ShouldBeTrue)
+		})
+
+		Convey("a standalone should support none of them", func() {
+			info := replSet(wireVersion42, "4.2", "wiredTiger")
+			info.NodeType = Standalone
+			info.Features = computeFeatures(info)
+			So(info.Supports(FeatureTransactions), ShouldBeFalse)
+			So(info.Supports(FeatureRetryableWrites), ShouldBeFalse)
+			So(info.Supports(FeatureChangeStreams), ShouldBeFalse)
+		})
+	})
+
+	Convey("The FCV should be parsed from either of its forms", t, func() {
+		fcvParameter := func(value interface{}) bson.RawValue {
+			doc, err := bson.Marshal(bson.D{{"featureCompatibilityVersion", value}})
+			So(err, ShouldBeNil)
+			return bson.Raw(doc).Lookup("featureCompatibilityVersion")
+		}
+		So(parseFCV(fcvParameter("3.4")), ShouldEqual, "3.4")
+		So(parseFCV(fcvParameter(bson.D{{"version", "4.2"}})), ShouldEqual, "4.2")
+
+		So(fcvAtLeast("4.2", "4.0"), ShouldBeTrue)
+		So(fcvAtLeast("3.6", "4.0"), ShouldBeFalse)
+		So(fcvAtLeast("", "4.0"), ShouldBeTrue)
+	})
+}
+
+func TestServerInfo(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+
+	auth := DBGetAuthOptions()
+	ssl := DBGetSSLOptions()
+
+	Convey("With a valid session provider", t, func() {
+		opts := options.ToolOptions{
+			Connection: &options.Connection{
+				Port: DefaultTestPort,
+			},
+			SSL:  &ssl,
+			Auth: &auth,
+		}
+		provider, err := NewSessionProvider(opts)
+		So(err, ShouldBeNil)
+
+		Convey("the individual probes should answer from the server info", func() {
+			info, err := provider.ServerInfo()
+			So(err, ShouldBeNil)
+
+			nodeType, err := provider.GetNodeType()
+			So(err, ShouldBeNil)
+			So(nodeType, ShouldEqual, info.NodeType)
+
+			isReplicaSet, err := provider.IsReplicaSet()
+			So(err, ShouldBeNil)
+			So(isReplicaSet, ShouldEqual, info.NodeType == ReplSet)
+
+			version, err := provider.ServerVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, info.VersionString)
+
+			versionArray, err := provider.ServerVersionArray()
+			So(err, ShouldBeNil)
+			So(versionArray, ShouldResemble, info.Version)
+
+			Convey("and be cached until refreshed", func() {
+				cached, err := provider.ServerInfo()
+				So(err, ShouldBeNil)
+				So(cached, ShouldEqual, info)
+
+				refreshed, err := provider.RefreshServerInfo()
+				So(err, ShouldBeNil)
+				So(refreshed, ShouldNotEqual, info)
+				So(refreshed.Version, ShouldResemble, info.Version)
+			})
+		})
+	})
+}
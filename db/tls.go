@@ -0,0 +1,302 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/options"
+	"golang.org/x/crypto/ocsp"
+)
+
+type tlsProtocol struct {
+	name    string
+	version uint16
+}
+
+// tlsProtocols lists the TLS protocols that can be named in
+// --tlsDisabledProtocols and --tlsMinVersion, in increasing order. TLS 1.3
+// is added when the toolchain supports it.
+var tlsProtocols = []tlsProtocol{
+	{"TLS1_0", tls.VersionTLS10},
+	{"TLS1_1", tls.VersionTLS11},
+	{"TLS1_2", tls.VersionTLS12},
+}
+
+// cipherSuites maps the names accepted by --tlsCipherSuites to cipher suite
+// IDs. The ChaCha20-Poly1305 suites are accepted with and without their
+// "_SHA256" suffix. TLS 1.3 suites are not configurable.
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                      tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":              tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":                tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// configureTLS builds the TLS configuration for the given ssl options.
+func configureTLS(opts *options.SSL) (*tls.Config, error) {
+	// Error on unsupported features
+	if opts.SSLFipsMode {
+		return nil, fmt.Errorf("FIPS mode not supported")
+	}
+
+	tlsConfig := &tls.Config{}
+	if opts.SSLAllowInvalidCert || opts.SSLAllowInvalidHost || opts.TLSInsecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if opts.SSLPEMKeyFile != "" {
+		_, err := addClientCertFromFile(tlsConfig, opts.SSLPEMKeyFile, opts.SSLPEMKeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %v", err)
+		}
+	}
+	if opts.SSLCAFile != "" {
+		if err := addCACertFromFile(tlsConfig, opts.SSLCAFile); err != nil {
+			return nil, fmt.Errorf("can't load CA file: %v", err)
+		}
+	}
+
+	minVersion, maxVersion, err := parseTLSVersions(opts.TLSDisabledProtocols, opts.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.MaxVersion = maxVersion
+
+	if opts.TLSCipherSuites != "" {
+		tlsConfig.CipherSuites, err = parseCipherSuites(opts.TLSCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var crls []*pkix.CertificateList
+	if opts.SSLCRLFile != "" {
+		crls, err = loadCRLs(opts.SSLCRLFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load CRL file: %v", err)
+		}
+	}
+	tlsConfig.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		// there is nothing to check unless the peer's chain was verified
+		if len(verifiedChains) == 0 {
+			return nil
+		}
+		return checkRevocationLists(verifiedChains, crls)
+	}
+	if opts.TLSCheckOCSP {
+		if !checksStapledOCSP {
+			return nil, fmt.Errorf("--tlsCheckOCSP is not supported by this build, which can't check stapled OCSP responses")
+		}
+		verifyStapledOCSP(tlsConfig)
+	}
+
+	return tlsConfig, nil
+}
+
+// parseTLSVersions returns the minimum and maximum TLS versions which are
+// left enabled by the given --tlsDisabledProtocols and --tlsMinVersion
+// values. Zero values are returned for bounds that were not restricted.
+func parseTLSVersions(disabledProtocols, minProtocol string) (uint16, uint16, error) {
+	if disabledProtocols == "" && minProtocol == "" {
+		return 0, 0, nil
+	}
+
+	disabled := make(map[string]bool)
+	if disabledProtocols != "" && disabledProtocols != "none" {
+		for _, name := range strings.Split(disabledProtocols, ",") {
+			name = strings.TrimSpace(name)
+			if !isTLSProtocol(name) {
+				return 0, 0, fmt.Errorf("unknown protocol '%v' in --tlsDisabledProtocols", name)
+			}
+			disabled[name] = true
+		}
+	}
+	if minProtocol != "" {
+		if !isTLSProtocol(minProtocol) {
+			return 0, 0, fmt.Errorf("unknown protocol '%v' for --tlsMinVersion", minProtocol)
+		}
+		for _, protocol := range tlsProtocols {
+			if protocol.name == minProtocol {
+				break
+			}
+			disabled[protocol.name] = true
+		}
+	}
+
+	// Go only supports a contiguous range of versions, so a protocol cannot be
+	// disabled if protocols both below and above it remain enabled.
+	var minVersion, maxVersion uint16
+	for _, protocol := range tlsProtocols {
+		if disabled[protocol.name] {
+			continue
+		}
+		if maxVersion != 0 && protocol.version != maxVersion+1 {
+			return 0, 0, fmt.Errorf("cannot disable a TLS protocol between two enabled protocols")
+		}
+		if minVersion == 0 {
+			minVersion = protocol.version
+		}
+		maxVersion = protocol.version
+	}
+	if minVersion == 0 {
+		return 0, 0, fmt.Errorf("all TLS protocols are disabled")
+	}
+	if maxVersion == tlsProtocols[len(tlsProtocols)-1].version {
+		// leave the maximum unrestricted so newer protocols can be used
+		maxVersion = 0
+	}
+	return minVersion, maxVersion, nil
+}
+
+func isTLSProtocol(name string) bool {
+	for _, protocol := range tlsProtocols {
+		if protocol.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCipherSuites returns the IDs of the comma-separated cipher suites.
+func parseCipherSuites(names string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%v' in --tlsCipherSuites", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadCRLs reads the certificate revocation lists in the given file, which
+// may contain any number of PEM "X509 CRL" blocks, or a single DER-encoded
+// list.
+func loadCRLs(file string) ([]*pkix.CertificateList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var crls []*pkix.CertificateList
+	remaining := data
+	for {
+		var block *pem.Block
+		block, remaining = pem.Decode(remaining)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		crl, err := x509.ParseDERCRL(data)
+		if err != nil {
+			return nil, fmt.Errorf("no X509 CRL found: %v", err)
+		}
+		crls = append(crls, crl)
+	}
+
+	for _, crl := range crls {
+		if crl.HasExpired(time.Now()) {
+			log.Logvf(log.Always, "WARNING: the CRL issued by %v in %v has expired",
+				crl.TBSCertList.Issuer, file)
+		}
+	}
+	return crls, nil
+}
+
+// checkRevocationLists returns an error unless at least one of the chains has
+// no certificate revoked by the given lists. A list applies to a certificate
+// if it is signed by the certificate's issuer.
+func checkRevocationLists(chains [][]*x509.Certificate, crls []*pkix.CertificateList) error {
+	if len(crls) == 0 {
+		return nil
+	}
+	var err error
+	for _, chain := range chains {
+		if err = checkChainRevocation(chain, crls); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func checkChainRevocation(chain []*x509.Certificate, crls []*pkix.CertificateList) error {
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range crls {
+			if issuer.CheckCRLSignature(crl) != nil {
+				continue
+			}
+			for _, revoked := range crl.TBSCertList.RevokedCertificates {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate for %v has been revoked", cert.Subject)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkStapledOCSP returns an error if the server stapled an OCSP response
+// to the handshake which is invalid or reports that the server's certificate
+// has been revoked.
+func checkStapledOCSP(response []byte, chain []*x509.Certificate) error {
+	if len(response) == 0 || len(chain) < 2 {
+		return nil
+	}
+	leaf, issuer := chain[0], chain[1]
+	resp, err := ocsp.ParseResponse(response, issuer)
+	if err != nil {
+		return fmt.Errorf("invalid stapled OCSP response: %v", err)
+	}
+	if resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return fmt.Errorf("stapled OCSP response is not for the server's certificate")
+	}
+	if resp.Status == ocsp.Revoked {
+		return fmt.Errorf("certificate for %v has been revoked according to its stapled OCSP response", leaf.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// +build go1.12
+
+package db
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTLS13Versions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("disabling TLS1_3 should cap the enabled range", t, func() {
+		minVersion, maxVersion, err := parseTLSVersions("TLS1_0, TLS1_3", "")
+		So(err, ShouldBeNil)
+		So(minVersion, ShouldEqual, tls.VersionTLS11)
+		So(maxVersion, ShouldEqual, tls.VersionTLS12)
+
+		minVersion, maxVersion, err = parseTLSVersions("TLS1_3", "TLS1_1")
+		So(err, ShouldBeNil)
+		So(minVersion, ShouldEqual, tls.VersionTLS11)
+		So(maxVersion, ShouldEqual, tls.VersionTLS12)
+	})
+}
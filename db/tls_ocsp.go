@@ -0,0 +1,26 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// +build go1.15
+
+package db
+
+import "crypto/tls"
+
+// checksStapledOCSP is true when stapled OCSP responses are checked.
+const checksStapledOCSP = true
+
+// verifyStapledOCSP makes the handshake fail if the server staples an OCSP
+// response which reports that its certificate has been revoked. The stapled
+// response is only available after the chain has been verified.
+func verifyStapledOCSP(tlsConfig *tls.Config) {
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) == 0 {
+			return nil
+		}
+		return checkStapledOCSP(cs.OCSPResponse, cs.VerifiedChains[0])
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// +build !go1.15
+
+package db
+
+import "crypto/tls"
+
+// checksStapledOCSP is true when stapled OCSP responses are checked.
+const checksStapledOCSP = false
+
+// verifyStapledOCSP does nothing: before Go 1.15, crypto/tls does not expose
+// the stapled OCSP response until after the handshake, which the driver
+// performs. configureTLS rejects --tlsCheckOCSP rather than calling it.
+func verifyStapledOCSP(tlsConfig *tls.Config) {}
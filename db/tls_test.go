@@ -0,0 +1,486 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/pbkdf2"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testCert is a generated certificate along with its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func (c testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c testCert) keyPEM() []byte {
+	der, err := x509.MarshalECPrivateKey(c.key.(*ecdsa.PrivateKey))
+	So(err, ShouldBeNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// newTestCert generates a certificate signed by the given issuer, or a
+// self-signed CA certificate if the issuer is nil.
+func newTestCert(serial int64, commonName string, issuer *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"MongoDB"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent, signer := template, crypto.Signer(key)
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+	return testCert{cert: cert, key: key}
+}
+
+func newTestCA() testCert {
+	return newTestCert(1, "ca", nil)
+}
+
+// newTestCRL generates a PEM-encoded CRL signed by the issuer which revokes
+// the given certificates.
+func newTestCRL(issuer testCert, revoked ...testCert) []byte {
+	var revokedCerts []pkix.RevokedCertificate
+	for _, c := range revoked {
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{
+			SerialNumber:   c.cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := issuer.cert.CreateCRL(rand.Reader, issuer.key, revokedCerts, time.Now(), time.Now().Add(time.Hour))
+	So(err, ShouldBeNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// startTLSServer starts a TLS listener on localhost which completes a
+// handshake with each client and then closes the connection.
+func startTLSServer(config *tls.Config) net.Listener {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	So(err, ShouldBeNil)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+// dialTLS connects to the listener with the configuration built from opts.
+func dialTLS(listener net.Listener, opts *options.SSL) error {
+	config, err := configureTLS(opts)
+	if err != nil {
+		return err
+	}
+	config.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func writeTestFile(dir, name string, contents []byte) string {
+	path := filepath.Join(dir, name)
+	So(ioutil.WriteFile(path, contents, 0600), ShouldBeNil)
+	return path
+}
+
+func TestConfigureTLS(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a local TLS server using generated certificates", t, func() {
+		dir, err := ioutil.TempDir("", "tls")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		ca := newTestCA()
+		server := newTestCert(2, "server", &ca)
+		client := newTestCert(3, "client", &ca)
+
+		caFile := writeTestFile(dir, "ca.pem", ca.certPEM())
+		clientFile := writeTestFile(dir, "client.pem", append(client.certPEM(), client.keyPEM()...))
+
+		serverConfig := &tls.Config{
+			Certificates: []tls.Certificate{server.tlsCertificate()},
+		}
+		opts := &options.SSL{UseSSL: true, SSLCAFile: caFile, SSLPEMKeyFile: clientFile}
+
+		Convey("a client trusting the CA should connect", func() {
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			So(dialTLS(listener, opts), ShouldBeNil)
+		})
+
+		Convey("a CRL revoking the server certificate should fail the handshake", func() {
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			opts.SSLCRLFile = writeTestFile(dir, "crl.pem", newTestCRL(ca, server))
+			err := dialTLS(listener, opts)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "revoked")
+		})
+
+		Convey("a CRL revoking other certificates should not fail the handshake", func() {
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			opts.SSLCRLFile = writeTestFile(dir, "crl.pem", newTestCRL(ca, client))
+			So(dialTLS(listener, opts), ShouldBeNil)
+		})
+
+		Convey("a CRL from another issuer should be ignored", func() {
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			otherCA := newTestCA()
+			opts.SSLCRLFile = writeTestFile(dir, "crl.pem", newTestCRL(otherCA, server))
+			So(dialTLS(listener, opts), ShouldBeNil)
+		})
+
+		Convey("an invalid CRL file should be an error", func() {
+			opts.SSLCRLFile = writeTestFile(dir, "crl.pem", []byte("not a crl"))
+			_, err := configureTLS(opts)
+			So(err, ShouldNotBeNil)
+		})
+
+		if !checksStapledOCSP {
+			Convey("requesting OCSP checks should be an error", func() {
+				opts.TLSCheckOCSP = true
+				_, err := configureTLS(opts)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "tlsCheckOCSP")
+			})
+		}
+
+		if checksStapledOCSP {
+			Convey("a stapled OCSP response", func() {
+				staple := func(status int) {
+					response, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+						Status:       status,
+						SerialNumber: server.cert.SerialNumber,
+						ThisUpdate:   time.Now(),
+						NextUpdate:   time.Now().Add(time.Hour),
+					}, ca.key)
+					So(err, ShouldBeNil)
+					serverConfig.Certificates[0].OCSPStaple = response
+				}
+				opts.TLSCheckOCSP = true
+
+				Convey("reporting the certificate as good should be accepted", func() {
+					staple(ocsp.Good)
+					listener := startTLSServer(serverConfig)
+					defer listener.Close()
+					So(dialTLS(listener, opts), ShouldBeNil)
+				})
+
+				Convey("reporting the certificate as revoked should fail the handshake", func() {
+					staple(ocsp.Revoked)
+					listener := startTLSServer(serverConfig)
+					defer listener.Close()
+					err := dialTLS(listener, opts)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "OCSP")
+				})
+
+				Convey("should be ignored unless OCSP checks are requested", func() {
+					staple(ocsp.Revoked)
+					listener := startTLSServer(serverConfig)
+					defer listener.Close()
+					opts.TLSCheckOCSP = false
+					So(dialTLS(listener, opts), ShouldBeNil)
+				})
+			})
+		}
+
+		Convey("disabling every protocol the server supports should fail the handshake", func() {
+			serverConfig.MaxVersion = tls.VersionTLS12
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			opts.TLSDisabledProtocols = "TLS1_0,TLS1_1,TLS1_2"
+			So(dialTLS(listener, opts), ShouldNotBeNil)
+
+			opts.TLSDisabledProtocols = "TLS1_0,TLS1_1"
+			So(dialTLS(listener, opts), ShouldBeNil)
+		})
+
+		Convey("the allowed cipher suites should be negotiated", func() {
+			serverConfig.MaxVersion = tls.VersionTLS12
+			listener := startTLSServer(serverConfig)
+			defer listener.Close()
+			opts.TLSCipherSuites = "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"
+			So(dialTLS(listener, opts), ShouldBeNil)
+
+			opts.TLSCipherSuites = "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+			So(dialTLS(listener, opts), ShouldNotBeNil)
+		})
+	})
+}
+
+// encryptPKCS8 encrypts the key with PBES2, using PBKDF2 with HMAC-SHA256 and
+// AES-256-CBC.
+func encryptPKCS8(key crypto.Signer, password string) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	So(err, ShouldBeNil)
+
+	salt, iv := make([]byte, 8), make([]byte, aes.BlockSize)
+	_, err = rand.Read(salt)
+	So(err, ShouldBeNil)
+	_, err = rand.Read(iv)
+	So(err, ShouldBeNil)
+
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, 2048, 32, sha256.New))
+	So(err, ShouldBeNil)
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	der = append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(der, der)
+
+	marshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		So(err, ShouldBeNil)
+		return asn1.RawValue{FullBytes: b}
+	}
+	info := encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm: oidPBES2,
+			Parameters: marshal(pbes2Params{
+				KeyDerivationFunc: pkix.AlgorithmIdentifier{
+					Algorithm: oidPBKDF2,
+					Parameters: marshal(pbkdf2Params{
+						Salt:           salt,
+						IterationCount: 2048,
+						PRF:            pkix.AlgorithmIdentifier{Algorithm: pbkdf2PRFs[2].oid, Parameters: asn1.NullRawValue},
+					}),
+				},
+				EncryptionScheme: pkix.AlgorithmIdentifier{
+					Algorithm:  pbes2Ciphers[2].oid,
+					Parameters: marshal(iv),
+				},
+			}),
+		},
+		EncryptedData: der,
+	}
+	encoded, err := asn1.Marshal(info)
+	So(err, ShouldBeNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encoded})
+}
+
+func TestClientCertificateFormats(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a generated client certificate", t, func() {
+		dir, err := ioutil.TempDir("", "tls")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		ca := newTestCA()
+		intermediate := newTestCert(2, "intermediate", &ca)
+		client := newTestCert(3, "client", &intermediate)
+
+		Convey("a PKCS#8 encrypted key", func() {
+			file := writeTestFile(dir, "client.pem", append(client.certPEM(), encryptPKCS8(client.key, "secret")...))
+
+			Convey("should be decrypted with the correct password", func() {
+				config := &tls.Config{}
+				subject, err := addClientCertFromFile(config, file, "secret")
+				So(err, ShouldBeNil)
+				So(subject, ShouldContainSubstring, "CN=client")
+				So(config.Certificates, ShouldHaveLength, 1)
+				So(config.Certificates[0].PrivateKey, ShouldResemble, client.key)
+			})
+
+			Convey("should report an incorrect password", func() {
+				_, err := addClientCertFromFile(&tls.Config{}, file, "wrong")
+				So(err, ShouldEqual, errIncorrectKeyPassword)
+			})
+
+			Convey("should report a missing password", func() {
+				_, err := addClientCertFromFile(&tls.Config{}, file, "")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "no password was given")
+			})
+		})
+
+		Convey("a PKCS#12 bundle", func() {
+			chain := []*x509.Certificate{intermediate.cert}
+			data, err := pkcs12.Modern.Encode(client.key, client.cert, chain, "secret")
+			So(err, ShouldBeNil)
+			file := writeTestFile(dir, "client.p12", data)
+
+			Convey("should load the certificate, key and chain", func() {
+				config := &tls.Config{}
+				subject, err := addClientCertFromFile(config, file, "secret")
+				So(err, ShouldBeNil)
+				So(subject, ShouldContainSubstring, "CN=client")
+				So(config.Certificates, ShouldHaveLength, 1)
+				So(config.Certificates[0].Certificate, ShouldResemble,
+					[][]byte{client.cert.Raw, intermediate.cert.Raw})
+				So(config.Certificates[0].PrivateKey, ShouldResemble, client.key)
+			})
+
+			Convey("should report an incorrect password", func() {
+				_, err := addClientCertFromFile(&tls.Config{}, file, "wrong")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "incorrect password for PKCS#12 file")
+			})
+
+			Convey("should report a key which does not match the certificate", func() {
+				other := newTestCert(4, "other", &intermediate)
+				data, err := pkcs12.Modern.Encode(other.key, client.cert, nil, "secret")
+				So(err, ShouldBeNil)
+				file := writeTestFile(dir, "mismatched.p12", data)
+				_, err = addClientCertFromFile(&tls.Config{}, file, "secret")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "does not match")
+			})
+		})
+
+		Convey("the client certificate subject should be the MONGODB-X509 username", func() {
+			file := writeTestFile(dir, "client.pem", append(client.certPEM(), client.keyPEM()...))
+			config := &tls.Config{}
+			subject, err := addClientCertFromFile(config, file, "")
+			So(err, ShouldBeNil)
+			username, err := clientCertSubject(config)
+			So(err, ShouldBeNil)
+			So(username, ShouldEqual, subject)
+			So(username, ShouldEqual, "CN=client,O=MongoDB")
+
+			_, err = clientCertSubject(nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a file which is neither PEM nor PKCS#12 should be an error", func() {
+			file := writeTestFile(dir, "client.pem", []byte("garbage"))
+			_, err := addClientCertFromFile(&tls.Config{}, file, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "neither PEM nor a valid PKCS#12")
+		})
+	})
+}
+
+func TestPublicKeysEqual(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("publicKeysEqual should compare keys by type and value", t, func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		So(err, ShouldBeNil)
+		otherRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		So(err, ShouldBeNil)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		copied := *rsaKey.Public().(*rsa.PublicKey)
+		So(publicKeysEqual(rsaKey.Public(), &copied), ShouldBeTrue)
+		So(publicKeysEqual(rsaKey.Public(), otherRSAKey.Public()), ShouldBeFalse)
+		So(publicKeysEqual(ecKey.Public(), ecKey.Public()), ShouldBeTrue)
+		So(publicKeysEqual(ecKey.Public(), otherECKey.Public()), ShouldBeFalse)
+		So(publicKeysEqual(ecKey.Public(), rsaKey.Public()), ShouldBeFalse)
+
+		type byteKey []byte
+		So(publicKeysEqual(byteKey{1, 2}, byteKey{1, 2}), ShouldBeTrue)
+		So(publicKeysEqual(byteKey{1, 2}, byteKey{1, 3}), ShouldBeFalse)
+		So(publicKeysEqual(byteKey{1, 2}, nil), ShouldBeFalse)
+	})
+}
+
+func TestParseTLSVersions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("parseTLSVersions should compute the enabled range", t, func() {
+		cases := []struct {
+			disabled, min string
+			minVersion    uint16
+			maxVersion    uint16
+		}{
+			{"", "", 0, 0},
+			{"none", "", tls.VersionTLS10, 0},
+			{"TLS1_0,TLS1_1", "", tls.VersionTLS12, 0},
+			{"", "TLS1_2", tls.VersionTLS12, 0},
+		}
+		for _, c := range cases {
+			minVersion, maxVersion, err := parseTLSVersions(c.disabled, c.min)
+			So(err, ShouldBeNil)
+			So(minVersion, ShouldEqual, c.minVersion)
+			So(maxVersion, ShouldEqual, c.maxVersion)
+		}
+	})
+
+	Convey("parseTLSVersions should reject invalid combinations", t, func() {
+		cases := []struct{ disabled, min string }{
+			{"SSL3", ""},
+			{"", "TLS2_0"},
+			{"TLS1_1", ""},
+			{"TLS1_0,TLS1_1,TLS1_2,TLS1_3", ""},
+			{"TLS1_3", "TLS1_3"},
+		}
+		for _, c := range cases {
+			_, _, err := parseTLSVersions(c.disabled, c.min)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("parseCipherSuites should reject unknown suites", t, func() {
+		_, err := parseCipherSuites("TLS_RSA_WITH_AES_128_CBC_SHA,NOT_A_SUITE")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,249 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// throughputSmoothing is the weight of the newest observation in the moving
+// average of observed throughput.
+const throughputSmoothing = 0.5
+
+// CostModel estimates the relative cost of restoring an intent.  The cost of
+// a collection is
+//
+//	ByteCost*Size + DocCount*(DocCost + IndexCost*IndexCount)
+//
+// scaled by SystemFactor for system collections.  Views cost nothing.
+type CostModel struct {
+	// ByteCost is the cost per unit of Intent.Size.
+	ByteCost float64
+	// DocCost is the cost per document, and IndexCost is the additional cost
+	// per document for each index, which must be maintained on insert.
+	DocCost   float64
+	IndexCost float64
+	// SystemFactor scales the cost of collections named "system.*".
+	SystemFactor float64
+}
+
+// DefaultCostModel weights each document and index entry as a small, fixed
+// number of bytes of work.
+var DefaultCostModel = CostModel{
+	ByteCost:     1,
+	DocCost:      64,
+	IndexCost:    32,
+	SystemFactor: 1,
+}
+
+// Cost returns the model's estimated cost of restoring an intent.
+func (m CostModel) Cost(intent *Intent) float64 {
+	if intent.IsView() {
+		return 0
+	}
+	cost := m.ByteCost*float64(intent.Size) +
+		float64(intent.DocCount)*(m.DocCost+m.IndexCost*float64(intent.IndexCount))
+	if strings.HasPrefix(intent.C, "system.") {
+		cost *= m.SystemFactor
+	}
+	return cost
+}
+
+// CostPrioritizerOptions configures a CostPrioritizer.
+type CostPrioritizerOptions struct {
+	Model CostModel
+	// MaxPerDB and MaxPerShard limit the number of intents in progress for a
+	// database or a shard.  Zero means no limit.
+	MaxPerDB    int
+	MaxPerShard int
+	// ShardOf returns the shard an intent is restored to, for MaxPerShard.  If
+	// nil, every intent is considered to be on the same shard.
+	ShardOf func(*Intent) string
+}
+
+type costItem struct {
+	intent *Intent
+	cost   float64
+}
+
+// CostPrioritizer schedules intents by the estimated time to restore them,
+// longest first, with views at the front of the queue.  The estimate is an
+// intent's cost under the CostModel divided by the throughput observed for its
+// database, measured from Get to Finish, so that databases which restore more
+// slowly than the model predicts are started earlier.  Until throughput has
+// been observed for any database, intents are ordered by cost alone.
+//
+// Intents are held back until the intents they depend on have finished.  When
+// every remaining intent is blocked by its dependencies or the per-database or
+// per-shard limits, Get waits for an intent in progress to Finish.
+type CostPrioritizer struct {
+	sync.Mutex
+	cond *sync.Cond
+	opts CostPrioritizerOptions
+
+	queue       []costItem
+	activeDB    map[string]int
+	activeShard map[string]int
+	started     map[*Intent]time.Time
+	unfinished  dependencyCounter
+
+	// throughput is the moving average of cost per second per database
+	throughput       map[string]float64
+	globalThroughput float64
+
+	now func() time.Time
+}
+
+// NewCostPrioritizer returns a CostPrioritizer for the given intents.
+func NewCostPrioritizer(intents []*Intent, opts CostPrioritizerOptions) *CostPrioritizer {
+	p := &CostPrioritizer{
+		opts:        opts,
+		queue:       make([]costItem, 0, len(intents)),
+		activeDB:    map[string]int{},
+		activeShard: map[string]int{},
+		started:     map[*Intent]time.Time{},
+		unfinished:  newDependencyCounter(intents),
+		throughput:  map[string]float64{},
+		now:         time.Now,
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+	for _, intent := range intents {
+		p.queue = append(p.queue, costItem{intent: intent, cost: opts.Model.Cost(intent)})
+	}
+	return p
+}
+
+// Get returns the intent with the longest estimated restore time among those
+// allowed by the concurrency limits, waiting if necessary, or nil once every
+// intent has been returned.
+func (p *CostPrioritizer) Get() *Intent {
+	p.Lock()
+	defer p.Unlock()
+
+	for {
+		if len(p.queue) == 0 {
+			return nil
+		}
+		if i := p.next(); i >= 0 {
+			item := p.queue[i]
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.activeDB[item.intent.DB]++
+			p.activeShard[p.shardOf(item.intent)]++
+			p.started[item.intent] = p.now()
+			return item.intent
+		}
+		p.cond.Wait()
+	}
+}
+
+// Finish records the throughput of a finished intent and releases its place
+// in the concurrency limits.
+func (p *CostPrioritizer) Finish(intent *Intent) {
+	p.Lock()
+	defer p.Unlock()
+
+	start, ok := p.started[intent]
+	if !ok {
+		return
+	}
+	delete(p.started, intent)
+	p.unfinished.finish(intent)
+	p.activeDB[intent.DB]--
+	p.activeShard[p.shardOf(intent)]--
+	p.cond.Broadcast()
+
+	cost := p.opts.Model.Cost(intent)
+	elapsed := p.now().Sub(start).Seconds()
+	if cost <= 0 || elapsed <= 0 {
+		return
+	}
+	observed := cost / elapsed
+	p.throughput[intent.DB] = smoothThroughput(p.throughput[intent.DB], observed)
+	p.globalThroughput = smoothThroughput(p.globalThroughput, observed)
+}
+
+func smoothThroughput(average, observed float64) float64 {
+	if average == 0 {
+		return observed
+	}
+	return throughputSmoothing*observed + (1-throughputSmoothing)*average
+}
+
+// schedulesDependencies marks CostPrioritizer as dependencyAware, since
+// holding back intents outside of it could leave it waiting on its limits.
+func (p *CostPrioritizer) schedulesDependencies() {}
+
+// next returns the index of the highest priority intent allowed by the
+// limits, or -1 if there is none.  This is a linear scan, since estimates
+// change as throughput is observed; the number of intents is small enough
+// that this is negligible next to restoring them.
+func (p *CostPrioritizer) next() int {
+	// If nothing is in progress, nothing can finish to satisfy the limits or
+	// dependencies, so ignore them rather than wait forever.
+	idle := len(p.started) == 0
+	if best := p.pick(idle, false); best >= 0 || !idle {
+		return best
+	}
+	return p.pick(true, true)
+}
+
+func (p *CostPrioritizer) pick(ignoreLimits, ignoreDependencies bool) int {
+	best := -1
+	for i, item := range p.queue {
+		if !ignoreLimits && !p.allowed(item.intent) {
+			continue
+		}
+		if !ignoreDependencies && !p.unfinished.ready(item.intent) {
+			continue
+		}
+		if best < 0 || p.before(item, p.queue[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (p *CostPrioritizer) allowed(intent *Intent) bool {
+	if p.opts.MaxPerDB > 0 && p.activeDB[intent.DB] >= p.opts.MaxPerDB {
+		return false
+	}
+	if p.opts.MaxPerShard > 0 && p.activeShard[p.shardOf(intent)] >= p.opts.MaxPerShard {
+		return false
+	}
+	return true
+}
+
+// before reports whether a should be restored before b.  Ties keep discovery
+// order.
+func (p *CostPrioritizer) before(a, b costItem) bool {
+	if a.intent.IsView() != b.intent.IsView() {
+		return a.intent.IsView()
+	}
+	return p.estimate(a) > p.estimate(b)
+}
+
+// estimate returns the estimated restore time of an item, in seconds once
+// throughput has been observed and in units of cost before then.
+func (p *CostPrioritizer) estimate(item costItem) float64 {
+	rate, ok := p.throughput[item.intent.DB]
+	if !ok {
+		rate = p.globalThroughput
+	}
+	if rate <= 0 {
+		return item.cost
+	}
+	return item.cost / rate
+}
+
+func (p *CostPrioritizer) shardOf(intent *Intent) string {
+	if p.opts.ShardOf == nil {
+		return ""
+	}
+	return p.opts.ShardOf(intent)
+}
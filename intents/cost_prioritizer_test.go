@@ -0,0 +1,144 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCostModel(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a cost model", t, func() {
+		model := CostModel{ByteCost: 1, DocCost: 10, IndexCost: 5, SystemFactor: 2}
+
+		Convey("collection cost should count bytes, documents and indexes", func() {
+			intent := &Intent{DB: "db", C: "c", Size: 100, DocCount: 10, IndexCount: 2}
+			So(model.Cost(intent), ShouldEqual, 100+10*(10+5*2))
+		})
+
+		Convey("system collections should be scaled", func() {
+			intent := &Intent{DB: "db", C: "system.js", Size: 100}
+			So(model.Cost(intent), ShouldEqual, 200)
+		})
+
+		Convey("views should cost nothing", func() {
+			intent := &Intent{DB: "db", C: "v", Size: 100, Options: bson.M{"viewOn": "c"}}
+			So(model.Cost(intent), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestCostPrioritizer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a cost prioritizer", t, func() {
+		opts := CostPrioritizerOptions{Model: DefaultCostModel}
+
+		Convey("views should come first, then intents by decreasing cost", func() {
+			prioritizer := NewCostPrioritizer([]*Intent{
+				{DB: "a", C: "big", Size: 10000},
+				{DB: "a", C: "small", Size: 10},
+				{DB: "a", C: "view", Options: bson.M{"viewOn": "elsewhere"}},
+				{DB: "b", C: "indexed", Size: 1000, DocCount: 100, IndexCount: 5},
+			}, opts)
+			So(prioritizer.Get().C, ShouldEqual, "view")
+			So(prioritizer.Get().C, ShouldEqual, "indexed")
+			So(prioritizer.Get().C, ShouldEqual, "big")
+			So(prioritizer.Get().C, ShouldEqual, "small")
+			So(prioritizer.Get(), ShouldBeNil)
+		})
+
+		Convey("the per-database limit should be respected", func() {
+			opts.MaxPerDB = 1
+			prioritizer := NewCostPrioritizer([]*Intent{
+				{DB: "a", C: "1", Size: 300},
+				{DB: "a", C: "2", Size: 200},
+				{DB: "b", C: "3", Size: 100},
+			}, opts)
+			first := prioritizer.Get()
+			So(first.C, ShouldEqual, "1")
+			So(prioritizer.Get().C, ShouldEqual, "3")
+
+			got := make(chan *Intent)
+			go func() { got <- prioritizer.Get() }()
+			select {
+			case <-got:
+				t.Fatal("Get should wait while the database is at its limit")
+			case <-time.After(50 * time.Millisecond):
+			}
+			prioritizer.Finish(first)
+			So((<-got).C, ShouldEqual, "2")
+		})
+
+		Convey("the per-shard limit should be respected", func() {
+			opts.MaxPerShard = 1
+			opts.ShardOf = func(intent *Intent) string {
+				if intent.DB == "c" {
+					return "shard1"
+				}
+				return "shard0"
+			}
+			prioritizer := NewCostPrioritizer([]*Intent{
+				{DB: "a", C: "1", Size: 300},
+				{DB: "b", C: "2", Size: 200},
+				{DB: "c", C: "3", Size: 100},
+			}, opts)
+			So(prioritizer.Get().DB, ShouldEqual, "a")
+			So(prioritizer.Get().DB, ShouldEqual, "c")
+		})
+
+		Convey("observed throughput should change the order", func() {
+			clock := time.Unix(0, 0)
+			prioritizer := NewCostPrioritizer([]*Intent{
+				{DB: "fast", C: "1", Size: 200},
+				{DB: "slow", C: "1", Size: 150},
+				{DB: "fast", C: "2", Size: 100},
+				{DB: "slow", C: "2", Size: 90},
+			}, opts)
+			prioritizer.now = func() time.Time { return clock }
+
+			fast := prioritizer.Get()
+			slow := prioritizer.Get()
+			So(fast.DB, ShouldEqual, "fast")
+			So(slow.DB, ShouldEqual, "slow")
+
+			clock = clock.Add(time.Second)
+			prioritizer.Finish(fast)
+			clock = clock.Add(14 * time.Second)
+			prioritizer.Finish(slow)
+
+			// 90 at 10/s takes longer than 100 at 200/s
+			So(prioritizer.Get().DB, ShouldEqual, "slow")
+			So(prioritizer.Get().DB, ShouldEqual, "fast")
+		})
+	})
+}
+
+func TestManagerCostBasedFinalize(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a manager finalized with the cost-based prioritizer", t, func() {
+		manager := NewIntentManager()
+		manager.Put(&Intent{DB: "a", C: "1", Size: 10})
+		manager.Put(&Intent{DB: "a", C: "2", Size: 10, DocCount: 10})
+		manager.Finalize(CostBased)
+
+		Convey("intents should be popped by decreasing cost", func() {
+			first := manager.Pop()
+			So(first.C, ShouldEqual, "2")
+			manager.Finish(first)
+			So(manager.Pop().C, ShouldEqual, "1")
+			So(manager.Pop(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DependencyCycleError occurs when intents depend on each other in a cycle.
+type DependencyCycleError struct {
+	// Cycle lists the namespaces in the cycle, starting and ending with the
+	// same namespace.
+	Cycle []string
+}
+
+func (e DependencyCycleError) Error() string {
+	return fmt.Sprintf("intent dependency cycle: %v", strings.Join(e.Cycle, " -> "))
+}
+
+// dependencyGraph maps the source namespace of each intent to the source
+// namespaces of the other intents it depends on. Dependencies on namespaces without an intent
+// are left out, since there is nothing to wait for.
+func dependencyGraph(intents []*Intent) map[string][]string {
+	graph := make(map[string][]string, len(intents))
+	for _, intent := range intents {
+		graph[intent.sourceNamespace()] = nil
+	}
+	for _, intent := range intents {
+		ns := intent.sourceNamespace()
+		for _, dep := range intent.Dependencies() {
+			if _, ok := graph[dep]; ok && dep != ns {
+				graph[ns] = append(graph[ns], dep)
+			}
+		}
+	}
+	return graph
+}
+
+// findDependencyCycle returns an error for the first dependency cycle among
+// the intents, visiting them in order, or nil if there is none.
+func findDependencyCycle(intents []*Intent) error {
+	graph := dependencyGraph(intents)
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(graph))
+	var path []string
+
+	var visit func(ns string) error
+	visit = func(ns string) error {
+		switch state[ns] {
+		case visited:
+			return nil
+		case visiting:
+			for i := range path {
+				if path[i] == ns {
+					cycle := append([]string(nil), path[i:]...)
+					return DependencyCycleError{Cycle: append(cycle, ns)}
+				}
+			}
+		}
+		state[ns] = visiting
+		path = append(path, ns)
+		for _, dep := range graph[ns] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[ns] = visited
+		return nil
+	}
+
+	for _, intent := range intents {
+		if err := visit(intent.sourceNamespace()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencyCounter tracks which namespaces still have unfinished intents.
+type dependencyCounter map[string]int
+
+func newDependencyCounter(intents []*Intent) dependencyCounter {
+	counter := dependencyCounter{}
+	for _, intent := range intents {
+		counter[intent.sourceNamespace()]++
+	}
+	return counter
+}
+
+// ready returns true if no other intent the given one depends on is
+// unfinished.
+func (c dependencyCounter) ready(intent *Intent) bool {
+	ns := intent.sourceNamespace()
+	for _, dep := range intent.Dependencies() {
+		if dep != ns && c[dep] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c dependencyCounter) finish(intent *Intent) {
+	c[intent.sourceNamespace()]--
+}
+
+// dependencyAware is implemented by prioritizers which already hold back
+// intents until their dependencies finish.
+type dependencyAware interface {
+	schedulesDependencies()
+}
+
+// dependencyPrioritizer holds back the intents returned by another
+// prioritizer until the intents they depend on have finished, returning
+// intents which are ready in the meantime. The order among ready intents is
+// that of the wrapped prioritizer.
+type dependencyPrioritizer struct {
+	sync.Mutex
+	cond  *sync.Cond
+	inner IntentPrioritizer
+
+	unfinished dependencyCounter
+	// held are intents returned by the wrapped prioritizer which are waiting
+	// for their dependencies, in the order they were returned
+	held []*Intent
+	// only one caller at a time gets the next intent from the wrapped
+	// prioritizer, without holding the lock, since its Get may wait on Finish
+	fetching  bool
+	innerDone bool
+}
+
+// withDependencies wraps a prioritizer of the given intents so that it
+// respects their dependencies, unless it already does.
+func withDependencies(prioritizer IntentPrioritizer, intents []*Intent) IntentPrioritizer {
+	if _, ok := prioritizer.(dependencyAware); ok {
+		return prioritizer
+	}
+	p := &dependencyPrioritizer{
+		inner:      prioritizer,
+		unfinished: newDependencyCounter(intents),
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+	return p
+}
+
+func (p *dependencyPrioritizer) Get() *Intent {
+	p.Lock()
+	defer p.Unlock()
+
+	for {
+		for i, intent := range p.held {
+			if p.unfinished.ready(intent) {
+				p.held = append(p.held[:i], p.held[i+1:]...)
+				return intent
+			}
+		}
+		if p.innerDone && len(p.held) == 0 {
+			return nil
+		}
+		if p.innerDone || p.fetching {
+			p.cond.Wait()
+			continue
+		}
+
+		p.fetching = true
+		p.Unlock()
+		intent := p.inner.Get()
+		p.Lock()
+		p.fetching = false
+		if intent == nil {
+			p.innerDone = true
+		} else {
+			p.held = append(p.held, intent)
+		}
+		p.cond.Broadcast()
+	}
+}
+
+func (p *dependencyPrioritizer) Finish(intent *Intent) {
+	p.inner.Finish(intent)
+
+	p.Lock()
+	defer p.Unlock()
+	p.unfinished.finish(intent)
+	p.cond.Broadcast()
+}
+
+// CheckDependencies returns a DependencyCycleError if the intents in the
+// manager depend on each other in a cycle, which Finalize would return.
+func (mgr *Manager) CheckDependencies() error {
+	return findDependencyCycle(mgr.intentsByDiscoveryOrder)
+}
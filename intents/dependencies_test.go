@@ -0,0 +1,184 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func viewIntent(db, c, viewOn string) *Intent {
+	return &Intent{DB: db, C: c, Options: bson.M{"viewOn": viewOn}}
+}
+
+func TestIntentDependencies(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Intents should depend on", t, func() {
+		Convey("their declared namespaces", func() {
+			intent := &Intent{DB: "a", C: "b", DependsOn: []string{"a.c"}}
+			So(intent.Dependencies(), ShouldResemble, []string{"a.c"})
+		})
+
+		Convey("the source of a view", func() {
+			So(viewIntent("a", "v", "c").Dependencies(), ShouldResemble, []string{"a.c"})
+		})
+
+		Convey("the time-series collection of its buckets", func() {
+			intent := &Intent{DB: "a", C: "system.buckets.ts"}
+			So(intent.Dependencies(), ShouldResemble, []string{"a.ts"})
+
+			ts := &Intent{DB: "a", C: "ts", Options: bson.M{"timeseries": bson.M{"timeField": "t"}}}
+			So(ts.Dependencies(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Dependency cycles", t, func() {
+		Convey("should be reported", func() {
+			err := findDependencyCycle([]*Intent{
+				{DB: "a", C: "c"},
+				viewIntent("a", "v1", "v2"),
+				viewIntent("a", "v2", "v3"),
+				viewIntent("a", "v3", "v1"),
+			})
+			So(err, ShouldResemble, DependencyCycleError{Cycle: []string{"a.v1", "a.v2", "a.v3", "a.v1"}})
+			So(err.Error(), ShouldEqual, "intent dependency cycle: a.v1 -> a.v2 -> a.v3 -> a.v1")
+		})
+
+		Convey("should not include namespaces without intents", func() {
+			err := findDependencyCycle([]*Intent{
+				viewIntent("a", "v1", "missing"),
+				{DB: "a", C: "c", DependsOn: []string{"a.missing"}},
+			})
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestDependencyScheduling(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a manager of intents with dependencies", t, func() {
+		manager := NewIntentManager()
+		manager.Put(viewIntent("a", "v2", "v1"))
+		manager.Put(viewIntent("a", "v1", "c"))
+		manager.Put(&Intent{DB: "a", C: "c", Size: 10})
+		manager.Put(&Intent{DB: "b", C: "big", Size: 1000})
+		So(manager.CheckDependencies(), ShouldBeNil)
+
+		prioritizers := map[string]PriorityType{
+			"legacy":           Legacy,
+			"longestTaskFirst": LongestTaskFirst,
+			"multiDatabaseLTF": MultiDatabaseLTF,
+			"costBased":        CostBased,
+		}
+		for name, pType := range prioritizers {
+			pType := pType
+			Convey("finalized with the "+name+" prioritizer", func() {
+				So(manager.Finalize(pType), ShouldBeNil)
+
+				Convey("independent intents should run while dependencies are in progress", func() {
+					first := manager.Pop()
+					second := manager.Pop()
+					So([]string{first.C, second.C}, ShouldContain, "c")
+					So([]string{first.C, second.C}, ShouldContain, "big")
+
+					got := make(chan *Intent, 1)
+					go func() { got <- manager.Pop() }()
+					manager.Finish(first)
+					manager.Finish(second)
+					v1 := <-got
+					So(v1.C, ShouldEqual, "v1")
+					manager.Finish(v1)
+					v2 := manager.Pop()
+					So(v2.C, ShouldEqual, "v2")
+					manager.Finish(v2)
+					So(manager.Pop(), ShouldBeNil)
+				})
+			})
+		}
+	})
+
+	Convey("With a manager of intents in a dependency cycle", t, func() {
+		manager := NewIntentManager()
+		manager.Put(viewIntent("a", "v1", "v2"))
+		manager.Put(viewIntent("a", "v2", "v1"))
+		So(manager.CheckDependencies(), ShouldHaveSameTypeAs, DependencyCycleError{})
+
+		Convey("finalizing should return the cycle", func() {
+			err := manager.Finalize(Legacy)
+			So(err, ShouldResemble, DependencyCycleError{Cycle: []string{"a.v1", "a.v2", "a.v1"}})
+			So(manager.FinalizeWithCostModel(CostPrioritizerOptions{Model: DefaultCostModel}),
+				ShouldHaveSameTypeAs, DependencyCycleError{})
+		})
+	})
+
+	Convey("With a manager of a time-series collection and its buckets", t, func() {
+		manager := NewIntentManager()
+		manager.Put(&Intent{DB: "a", C: "system.buckets.ts", Size: 1000})
+		manager.Put(&Intent{DB: "a", C: "ts", Options: bson.M{"timeseries": bson.M{"timeField": "t"}}})
+		So(manager.Finalize(LongestTaskFirst), ShouldBeNil)
+
+		Convey("the buckets should wait for the time-series collection", func() {
+			ts := manager.Pop()
+			So(ts.C, ShouldEqual, "ts")
+
+			got := make(chan *Intent, 1)
+			go func() { got <- manager.Pop() }()
+			time.Sleep(10 * time.Millisecond)
+			So(len(got), ShouldEqual, 0)
+			manager.Finish(ts)
+			buckets := <-got
+			So(buckets.C, ShouldEqual, "system.buckets.ts")
+			manager.Finish(buckets)
+			So(manager.Pop(), ShouldBeNil)
+		})
+	})
+
+	Convey("With a manager which renames the source of a view", t, func() {
+		renamer, err := NewNamespaceRenamer([]string{"a.c"}, []string{"b.renamed"})
+		So(err, ShouldBeNil)
+		manager := NewIntentManager()
+		manager.SetNamespaceRenamer(renamer)
+		manager.Put(viewIntent("a", "v", "c"))
+		manager.Put(&Intent{DB: "a", C: "c", Size: 10})
+		So(manager.Finalize(Legacy), ShouldBeNil)
+
+		Convey("the view should still wait for its renamed source", func() {
+			source := manager.Pop()
+			So(source.Namespace(), ShouldEqual, "b.renamed")
+
+			got := make(chan *Intent, 1)
+			go func() { got <- manager.Pop() }()
+			time.Sleep(10 * time.Millisecond)
+			So(len(got), ShouldEqual, 0)
+			manager.Finish(source)
+			view := <-got
+			So(view.Namespace(), ShouldEqual, "a.v")
+			manager.Finish(view)
+			So(manager.Pop(), ShouldBeNil)
+		})
+	})
+
+	Convey("With a cost prioritizer limited to one intent per database", t, func() {
+		prioritizer := NewCostPrioritizer([]*Intent{
+			viewIntent("a", "v", "c"),
+			{DB: "a", C: "c", Size: 10},
+		}, CostPrioritizerOptions{Model: DefaultCostModel, MaxPerDB: 1})
+
+		Convey("a view should not take its source's place", func() {
+			c := prioritizer.Get()
+			So(c.C, ShouldEqual, "c")
+			prioritizer.Finish(c)
+			So(prioritizer.Get().C, ShouldEqual, "v")
+		})
+	})
+}
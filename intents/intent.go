@@ -10,6 +10,8 @@ package intents
 import (
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/mongodb/mongo-tools-common/log"
 	"github.com/mongodb/mongo-tools-common/util"
@@ -66,6 +68,19 @@ type Intent struct {
 	// File/collection size, for some prioritizer implementations.
 	// Units don't matter as long as they are consistent for a given use case.
 	Size int64
+
+	// Estimated document count and number of indexes (from the collection's
+	// metadata), for the cost-based prioritizer.  Zero means unknown.
+	DocCount   int64
+	IndexCount int
+
+	// Source namespaces of other intents which must finish before this one
+	// starts, in addition to those implied by its options. See Dependencies.
+	DependsOn []string
+
+	// source is the namespace the intent was put into the manager with, if
+	// it was renamed
+	source string
 }
 
 func (it *Intent) Namespace() string {
@@ -130,6 +145,33 @@ func (it *Intent) IsView() bool {
 	return isView
 }
 
+// sourceNamespace returns the namespace the intent was put into the manager
+// with, which is its own namespace unless it was renamed.
+func (it *Intent) sourceNamespace() string {
+	if it.source != "" {
+		return it.source
+	}
+	return it.Namespace()
+}
+
+// Dependencies returns the source namespaces this intent depends on: those in
+// DependsOn, the source of a view and, for the buckets collection of a
+// time-series collection, the time-series collection, which creates it.
+// Since intents can be renamed, dependencies are matched against the source
+// namespaces of other intents, not their destinations.
+func (it *Intent) Dependencies() []string {
+	const bucketsPrefix = "system.buckets."
+	db, c := util.SplitNamespace(it.sourceNamespace())
+	deps := append([]string(nil), it.DependsOn...)
+	if viewOn, ok := it.Options["viewOn"].(string); ok {
+		deps = append(deps, db+"."+viewOn)
+	}
+	if strings.HasPrefix(c, bucketsPrefix) {
+		deps = append(deps, db+"."+strings.TrimPrefix(c, bucketsPrefix))
+	}
+	return deps
+}
+
 func (it *Intent) MergeIntent(newIt *Intent) {
 	// merge new intent into old intent
 	if it.BSONFile == nil {
@@ -138,6 +180,15 @@ func (it *Intent) MergeIntent(newIt *Intent) {
 	if it.Size == 0 {
 		it.Size = newIt.Size
 	}
+	if it.DocCount == 0 {
+		it.DocCount = newIt.DocCount
+	}
+	if it.IndexCount == 0 {
+		it.IndexCount = newIt.IndexCount
+	}
+	if len(it.DependsOn) == 0 {
+		it.DependsOn = newIt.DependsOn
+	}
 	if it.Location == "" {
 		it.Location = newIt.Location
 	}
@@ -184,6 +235,18 @@ type Manager struct {
 	// prevent conflicting destinations by checking which sources map to the
 	// same namespace
 	destinations map[string][]string
+
+	// select and rename regular intents by their source namespaces
+	nsMatcher *NamespaceMatcher
+	nsRenamer *NamespaceRenamer
+
+	// the plan, which is built from planInput by the first call to Plan
+	planLock  sync.Mutex
+	planInput *planInput
+	plan      *Plan
+
+	// outcomes of finished intents and the policies for failures
+	outcomes *outcomeTracker
 }
 
 func NewIntentManager() *Manager {
@@ -195,6 +258,7 @@ func NewIntentManager() *Manager {
 		smartPickOplog:          false,
 		oplogConflict:           false,
 		destinations:            map[string][]string{},
+		outcomes:                newOutcomeTracker(),
 	}
 }
 
@@ -202,6 +266,20 @@ func (mgr *Manager) SetSmartPickOplog(smartPick bool) {
 	mgr.smartPickOplog = smartPick
 }
 
+// SetNamespaceMatcher sets the matcher which selects regular intents by their
+// source namespaces. Intents which don't match are dropped by Put and
+// PutWithNamespace. Special collections and the oplog are not filtered.
+func (mgr *Manager) SetNamespaceMatcher(matcher *NamespaceMatcher) {
+	mgr.nsMatcher = matcher
+}
+
+// SetNamespaceRenamer sets the renamer which maps the source namespaces of
+// regular intents to their destinations. Sources renamed to the same
+// destination are reported by GetDestinationConflicts.
+func (mgr *Manager) SetNamespaceRenamer(renamer *NamespaceRenamer) {
+	mgr.nsRenamer = renamer
+}
+
 // HasConfigDBIntent returns a bool indicating if any of the intents refer to the "config" database.
 // This can be used to check for possible unwanted conflicts before restoring to a sharded system.
 func (mgr *Manager) HasConfigDBIntent() bool {
@@ -346,6 +424,17 @@ func (mgr *Manager) PutWithNamespace(ns string, intent *Intent) {
 		return
 	}
 
+	if mgr.nsMatcher != nil && !mgr.nsMatcher.Has(ns) {
+		log.Logvf(log.DebugLow, "skipping collection '%v', which does not match the namespace filters", ns)
+		return
+	}
+	if mgr.nsRenamer != nil {
+		intent.DB, intent.C = util.SplitNamespace(mgr.nsRenamer.Get(ns))
+	}
+	if ns != intent.Namespace() {
+		intent.source = ns
+	}
+
 	mgr.putNormalIntentWithNamespace(ns, intent)
 }
 
@@ -400,9 +489,30 @@ func (mgr *Manager) IntentForNamespace(ns string) *Intent {
 }
 
 // Pop returns the next available intent from the manager. If the manager is
-// empty, it returns nil. Pop is thread safe.
+// empty, or an intent has failed under the FailFast policy, it returns nil.
+// Intents which depend on a failed intent are not returned, but are finished
+// as failed with a DependencyFailedError.
+// Pop is thread safe.
 func (mgr *Manager) Pop() *Intent {
-	return mgr.prioritizer.Get()
+	for {
+		if mgr.outcomes.isAborted() {
+			return nil
+		}
+		intent := mgr.prioritizer.Get()
+		if intent != nil && mgr.outcomes.isAborted() {
+			// the job was stopped while waiting on the prioritizer
+			mgr.prioritizer.Finish(intent)
+			return nil
+		}
+		if intent == nil {
+			return nil
+		}
+		if dep, failed := mgr.outcomes.failedDependency(intent); failed {
+			mgr.finishAttempts(intent, 0, DependencyFailedError{Dependency: dep})
+			continue
+		}
+		return intent
+	}
 }
 
 // Peek returns a copy of a stored intent from the manager without removing
@@ -420,9 +530,9 @@ func (mgr *Manager) Peek() *Intent {
 }
 
 // Finish tells the prioritizer that mongorestore is done restoring
-// the given collection intent.
+// the given collection intent, and records that it succeeded.
 func (mgr *Manager) Finish(intent *Intent) {
-	mgr.prioritizer.Finish(intent)
+	mgr.FinishWithError(intent, nil)
 }
 
 // Oplog returns the intent representing the oplog, which isn't
@@ -464,25 +574,65 @@ func (mgr *Manager) AuthVersion() *Intent {
 // Finalize processes the intents for prioritization. Currently only two
 // kinds of prioritizers are supported. No more "Put" operations may be done
 // after finalize is called.
-func (mgr *Manager) Finalize(pType PriorityType) {
+// If the intents' dependencies form a cycle, Finalize returns a
+// DependencyCycleError and the manager is left unfinalized.
+func (mgr *Manager) Finalize(pType PriorityType) error {
 	switch pType {
 	case Legacy:
 		log.Logv(log.DebugHigh, "finalizing intent manager with legacy prioritizer")
-		mgr.prioritizer = newLegacyPrioritizer(mgr.intentsByDiscoveryOrder)
+		return mgr.finalize("legacy", func(intents []*Intent) IntentPrioritizer {
+			return newLegacyPrioritizer(intents)
+		})
 	case LongestTaskFirst:
 		log.Logv(log.DebugHigh, "finalizing intent manager with longest task first prioritizer")
-		mgr.prioritizer = newLongestTaskFirstPrioritizer(mgr.intentsByDiscoveryOrder)
+		return mgr.finalize("longestTaskFirst", func(intents []*Intent) IntentPrioritizer {
+			return newLongestTaskFirstPrioritizer(intents)
+		})
 	case MultiDatabaseLTF:
 		log.Logv(log.DebugHigh, "finalizing intent manager with multi-database longest task first prioritizer")
-		mgr.prioritizer = newMultiDatabaseLTFPrioritizer(mgr.intentsByDiscoveryOrder)
+		return mgr.finalize("multiDatabaseLTF", func(intents []*Intent) IntentPrioritizer {
+			return newMultiDatabaseLTFPrioritizer(intents)
+		})
+	case CostBased:
+		return mgr.FinalizeWithCostModel(CostPrioritizerOptions{Model: DefaultCostModel})
 	default:
 		panic("cannot initialize IntentPrioritizer with unknown type")
 	}
+}
+
+// FinalizeWithCostModel processes the intents for prioritization with a
+// CostPrioritizer configured by the given options. No more "Put" operations
+// may be done after it is called. Like Finalize, it returns a
+// DependencyCycleError if the intents' dependencies form a cycle.
+func (mgr *Manager) FinalizeWithCostModel(opts CostPrioritizerOptions) error {
+	log.Logv(log.DebugHigh, "finalizing intent manager with cost-based prioritizer")
+	return mgr.finalize("costBased", func(intents []*Intent) IntentPrioritizer {
+		return NewCostPrioritizer(intents, opts)
+	})
+}
+
+// finalize keeps what is needed to build the plan for the named prioritizer
+// and then creates it. The prioritizer holds back intents until those they
+// depend on have finished.
+func (mgr *Manager) finalize(name string, newUnordered func([]*Intent) IntentPrioritizer) error {
+	if err := mgr.CheckDependencies(); err != nil {
+		return err
+	}
+	newPrioritizer := func(intents []*Intent) IntentPrioritizer {
+		return withDependencies(newUnordered(intents), intents)
+	}
+	mgr.planInput = mgr.newPlanInput(name, newPrioritizer)
+	mgr.prioritizer = newPrioritizer(mgr.intentsByDiscoveryOrder)
 	// release these for the garbage collector and to ensure code correctness
 	mgr.intents = nil
 	mgr.intentsByDiscoveryOrder = nil
+	return nil
 }
 
 func (mgr *Manager) UsePrioritizer(prioritizer IntentPrioritizer) {
 	mgr.prioritizer = prioritizer
+	mgr.planLock.Lock()
+	defer mgr.planLock.Unlock()
+	mgr.planInput = nil
+	mgr.plan = nil
 }
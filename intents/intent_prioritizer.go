@@ -18,6 +18,7 @@ const (
 	Legacy PriorityType = iota
 	LongestTaskFirst
 	MultiDatabaseLTF
+	CostBased
 )
 
 // IntentPrioritizer encapsulates the logic of scheduling intents
@@ -0,0 +1,249 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mongodb/mongo-tools-common/util"
+)
+
+// Namespace patterns are of the form "db.collection", where the first
+// unescaped dot separates the database from the collection.  A backslash
+// escapes the next character, so that it is matched literally.  Patterns for
+// a NamespaceMatcher may use "*" to match any sequence of characters, and
+// patterns for a NamespaceRenamer may use variables like "$db$", which match
+// the same way and can be used in the target pattern.  Within the database
+// name, wildcards and variables never match a dot, but in a pattern without a
+// database separator, such as "*", they match whole namespaces.
+
+// patternPart is a literal string or a variable of a namespace pattern.
+type patternPart struct {
+	literal  string
+	variable string
+}
+
+type namespacePattern struct {
+	regex *regexp.Regexp
+	// vars are the names of the pattern's variables, in the order of the
+	// regex's capture groups
+	vars  []string
+	parts []patternPart
+}
+
+// parseNamespacePattern compiles a pattern, treating "*" as a wildcard if
+// wildcards is true and "$name$" as a variable otherwise.
+func parseNamespacePattern(pattern string, wildcards bool) (*namespacePattern, error) {
+	p := &namespacePattern{}
+	var regex, sample, literal strings.Builder
+	runes := []rune(pattern)
+	inCollection := !hasSeparator(runes)
+
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			p.parts = append(p.parts, patternPart{literal: literal.String()})
+			regex.WriteString(regexp.QuoteMeta(literal.String()))
+			sample.WriteString(literal.String())
+			literal.Reset()
+		}
+	}
+	addMatch := func(variable string) {
+		flushLiteral()
+		if inCollection {
+			regex.WriteString("(.*)")
+		} else {
+			regex.WriteString("([^.]*)")
+		}
+		sample.WriteString("a")
+		p.vars = append(p.vars, variable)
+		p.parts = append(p.parts, patternPart{variable: variable})
+	}
+
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("namespace pattern '%v' ends with an escape character", pattern)
+			}
+			i++
+			literal.WriteRune(runes[i])
+		case r == '.' && !inCollection:
+			literal.WriteRune(r)
+			inCollection = true
+		case r == '*' && wildcards:
+			addMatch("")
+		case r == '$' && !wildcards:
+			end := i + 1
+			for end < len(runes) && runes[end] != '$' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("namespace pattern '%v' has an unterminated variable", pattern)
+			}
+			name := string(runes[i+1 : end])
+			if name == "" {
+				return nil, fmt.Errorf("namespace pattern '%v' has an empty variable name", pattern)
+			}
+			for _, v := range p.vars {
+				if v == name {
+					return nil, fmt.Errorf("namespace pattern '%v' uses variable '$%v$' more than once", pattern, name)
+				}
+			}
+			addMatch(name)
+			i = end
+		default:
+			literal.WriteRune(r)
+		}
+	}
+	flushLiteral()
+
+	if err := validateNamespacePattern(sample.String()); err != nil {
+		return nil, fmt.Errorf("namespace pattern '%v' is not valid: %v", pattern, err)
+	}
+	var err error
+	p.regex, err = regexp.Compile("^" + regex.String() + "$")
+	if err != nil {
+		return nil, fmt.Errorf("namespace pattern '%v' is not valid: %v", pattern, err)
+	}
+	return p, nil
+}
+
+// hasSeparator returns true if a pattern has an unescaped dot.
+func hasSeparator(runes []rune) bool {
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '.':
+			return true
+		}
+	}
+	return false
+}
+
+// validateNamespacePattern validates a pattern with its wildcards and
+// variables filled in.  Patterns may name system collections, which
+// util.ValidateFullNamespace rejects, so those are checked separately.
+func validateNamespacePattern(namespace string) error {
+	db, collection := util.SplitNamespace(namespace)
+	if strings.HasPrefix(collection, "system.") {
+		if err := util.ValidateFullNamespace(db); err != nil {
+			return err
+		}
+		return util.ValidateCollectionGrammar(collection)
+	}
+	return util.ValidateFullNamespace(namespace)
+}
+
+// NamespaceMatcher selects namespaces by include and exclude patterns, as
+// given by --nsInclude and --nsExclude.
+type NamespaceMatcher struct {
+	include []*namespacePattern
+	exclude []*namespacePattern
+}
+
+// NewNamespaceMatcher returns a matcher for the given patterns.  If there
+// are no include patterns, every namespace which is not excluded matches.
+func NewNamespaceMatcher(include, exclude []string) (*NamespaceMatcher, error) {
+	m := &NamespaceMatcher{}
+	for _, pattern := range include {
+		p, err := parseNamespacePattern(pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		m.include = append(m.include, p)
+	}
+	for _, pattern := range exclude {
+		p, err := parseNamespacePattern(pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		m.exclude = append(m.exclude, p)
+	}
+	return m, nil
+}
+
+// Has returns true if the namespace is included and not excluded.
+func (m *NamespaceMatcher) Has(namespace string) bool {
+	for _, p := range m.exclude {
+		if p.regex.MatchString(namespace) {
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+	for _, p := range m.include {
+		if p.regex.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+type renameRule struct {
+	from, to *namespacePattern
+}
+
+// NamespaceRenamer maps source namespaces to destination namespaces by pairs
+// of patterns, as given by --nsFrom and --nsTo.
+type NamespaceRenamer struct {
+	rules []renameRule
+}
+
+// NewNamespaceRenamer returns a renamer for the given pairs of patterns.
+// Every variable in a "to" pattern must appear in the corresponding "from"
+// pattern.
+func NewNamespaceRenamer(from, to []string) (*NamespaceRenamer, error) {
+	if len(from) != len(to) {
+		return nil, fmt.Errorf("different number of source and target namespace patterns (%v and %v)",
+			len(from), len(to))
+	}
+	r := &NamespaceRenamer{}
+	for i := range from {
+		fromPattern, err := parseNamespacePattern(from[i], false)
+		if err != nil {
+			return nil, err
+		}
+		toPattern, err := parseNamespacePattern(to[i], false)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range toPattern.vars {
+			if util.StringSliceIndex(fromPattern.vars, v) < 0 {
+				return nil, fmt.Errorf("variable '$%v$' in namespace pattern '%v' is not in '%v'",
+					v, to[i], from[i])
+			}
+		}
+		r.rules = append(r.rules, renameRule{from: fromPattern, to: toPattern})
+	}
+	return r, nil
+}
+
+// Get returns the destination of a namespace by the first matching pair of
+// patterns, or the namespace itself if none matches.
+func (r *NamespaceRenamer) Get(namespace string) string {
+	for _, rule := range r.rules {
+		matches := rule.from.regex.FindStringSubmatch(namespace)
+		if matches == nil {
+			continue
+		}
+		var renamed strings.Builder
+		for _, part := range rule.to.parts {
+			if part.variable == "" {
+				renamed.WriteString(part.literal)
+				continue
+			}
+			i := util.StringSliceIndex(rule.from.vars, part.variable)
+			renamed.WriteString(matches[i+1])
+		}
+		return renamed.String()
+	}
+	return namespace
+}
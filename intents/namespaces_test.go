@@ -0,0 +1,133 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamespaceMatcher(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a namespace matcher", t, func() {
+		Convey("wildcards should match within the database or collection", func() {
+			matcher, err := NewNamespaceMatcher([]string{"test*.foo*"}, nil)
+			So(err, ShouldBeNil)
+			So(matcher.Has("test.foo"), ShouldBeTrue)
+			So(matcher.Has("test2.foo.bar"), ShouldBeTrue)
+			So(matcher.Has("test.bar"), ShouldBeFalse)
+			So(matcher.Has("other.foo"), ShouldBeFalse)
+		})
+
+		Convey("a lone wildcard should match every namespace", func() {
+			matcher, err := NewNamespaceMatcher([]string{"*"}, []string{"*.system.*"})
+			So(err, ShouldBeNil)
+			So(matcher.Has("a.b.c"), ShouldBeTrue)
+			So(matcher.Has("a.system.js"), ShouldBeFalse)
+		})
+
+		Convey("exclusions should override inclusions", func() {
+			matcher, err := NewNamespaceMatcher(nil, []string{"test.skip"})
+			So(err, ShouldBeNil)
+			So(matcher.Has("test.keep"), ShouldBeTrue)
+			So(matcher.Has("test.skip"), ShouldBeFalse)
+		})
+
+		Convey("escaped characters should match literally", func() {
+			matcher, err := NewNamespaceMatcher([]string{`test.a\*b\.c`}, nil)
+			So(err, ShouldBeNil)
+			So(matcher.Has("test.a*b.c"), ShouldBeTrue)
+			So(matcher.Has("test.aXb.c"), ShouldBeFalse)
+		})
+
+		Convey("invalid patterns should be rejected", func() {
+			for _, pattern := range []string{".foo", "te/st.*", "test.", `test.foo\`} {
+				_, err := NewNamespaceMatcher([]string{pattern}, nil)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestNamespaceRenamer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a namespace renamer", t, func() {
+		renamer, err := NewNamespaceRenamer(
+			[]string{"prod.users", "$db$.$coll$_old", "$db$.$coll$"},
+			[]string{"staging.users", "$db$_archive.$coll$", "$db$_copy.$coll$"},
+		)
+		So(err, ShouldBeNil)
+
+		Convey("the first matching pattern should be used", func() {
+			So(renamer.Get("prod.users"), ShouldEqual, "staging.users")
+			So(renamer.Get("prod.orders_old"), ShouldEqual, "prod_archive.orders")
+			So(renamer.Get("prod.orders.2019"), ShouldEqual, "prod_copy.orders.2019")
+		})
+
+		Convey("variables should not match across the database separator", func() {
+			renamer, err := NewNamespaceRenamer([]string{"$db$x.$coll$"}, []string{"$db$.$coll$"})
+			So(err, ShouldBeNil)
+			So(renamer.Get("ax.b.cx.d"), ShouldEqual, "a.b.cx.d")
+			So(renamer.Get("a.bx.c"), ShouldEqual, "a.bx.c")
+		})
+
+		Convey("unbalanced or unknown variables should be rejected", func() {
+			_, err := NewNamespaceRenamer([]string{"$db$.foo"}, nil)
+			So(err, ShouldNotBeNil)
+			_, err = NewNamespaceRenamer([]string{"$db$.foo"}, []string{"$other$.foo"})
+			So(err, ShouldNotBeNil)
+			_, err = NewNamespaceRenamer([]string{"$db.foo"}, []string{"bar.foo"})
+			So(err, ShouldNotBeNil)
+			_, err = NewNamespaceRenamer([]string{"$a$.$a$"}, []string{"$a$.foo"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestManagerNamespaceFilters(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With an IntentManager with a namespace matcher and renamer", t, func() {
+		matcher, err := NewNamespaceMatcher([]string{"test.*"}, []string{"test.skip"})
+		So(err, ShouldBeNil)
+		renamer, err := NewNamespaceRenamer([]string{"test.$coll$"}, []string{"dest.$coll$"})
+		So(err, ShouldBeNil)
+
+		manager := NewIntentManager()
+		manager.SetNamespaceMatcher(matcher)
+		manager.SetNamespaceRenamer(renamer)
+
+		Convey("filtered intents should never be queued", func() {
+			manager.Put(&Intent{DB: "test", C: "keep", Location: "/keep/"})
+			manager.Put(&Intent{DB: "test", C: "skip", Location: "/skip/"})
+			manager.Put(&Intent{DB: "other", C: "coll", Location: "/other/"})
+			manager.Put(&Intent{DB: "test", C: "keep", MetadataLocation: "/keep.m/"})
+			So(len(manager.intentsByDiscoveryOrder), ShouldEqual, 1)
+
+			intent := manager.IntentForNamespace("test.keep")
+			So(intent.Namespace(), ShouldEqual, "dest.keep")
+			So(intent.MetadataLocation, ShouldEqual, "/keep.m/")
+			So(manager.GetDestinationConflicts(), ShouldBeEmpty)
+		})
+
+		Convey("sources renamed to the same destination should conflict", func() {
+			renamer, err := NewNamespaceRenamer([]string{"test.b"}, []string{"test.a"})
+			So(err, ShouldBeNil)
+			manager.SetNamespaceRenamer(renamer)
+			manager.Put(&Intent{DB: "test", C: "a"})
+			manager.Put(&Intent{DB: "test", C: "b"})
+
+			conflicts := manager.GetDestinationConflicts()
+			So(len(conflicts), ShouldEqual, 2)
+			So(conflicts[0].Dst, ShouldEqual, "test.a")
+		})
+	})
+}
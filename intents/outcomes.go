@@ -0,0 +1,224 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+)
+
+// FailurePolicy chooses what a job does once an intent has failed.
+type FailurePolicy int
+
+const (
+	// FailFast stops handing out intents after the first failure.
+	FailFast FailurePolicy = iota
+	// ContinueOnError keeps going, so that every failure can be reported at
+	// the end.
+	ContinueOnError
+)
+
+// RetryPolicy configures how Attempt retries an intent. The zero value does
+// not retry.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed intent is retried.
+	MaxRetries int
+	// Backoff is the wait before the first retry, which doubles for each
+	// further retry up to MaxBackoff, if set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// IsTransient returns true for errors which are worth retrying. If nil,
+	// every error is retried.
+	IsTransient func(error) bool
+}
+
+func (p RetryPolicy) transient(err error) bool {
+	return p.IsTransient == nil || p.IsTransient(err)
+}
+
+// backoff returns the wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// IntentFailure describes an intent which failed.
+type IntentFailure struct {
+	Namespace string
+	Attempts  int
+	Err       error
+}
+
+func (f IntentFailure) Error() string {
+	return fmt.Sprintf("%v: %v", f.Namespace, f.Err)
+}
+
+// DependencyFailedError is the error of an intent which was not run because an
+// intent it depends on failed.
+type DependencyFailedError struct {
+	// Dependency is the source namespace of the failed intent.
+	Dependency string
+}
+
+func (e DependencyFailedError) Error() string {
+	return fmt.Sprintf("dependency failed: %v", e.Dependency)
+}
+
+// Report summarizes the outcomes of the intents finished so far.
+type Report struct {
+	// Succeeded lists the namespaces of the intents which succeeded.
+	Succeeded []string
+	// Failed lists the intents which failed, by namespace.
+	Failed []IntentFailure
+	// Aborted is true if the FailFast policy stopped the job.
+	Aborted bool
+}
+
+// Err returns an error listing the failed namespaces, or nil if none failed.
+func (r Report) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	failures := make([]string, len(r.Failed))
+	for i, f := range r.Failed {
+		failures[i] = f.Error()
+	}
+	return fmt.Errorf("%d of %d collections failed: %v",
+		len(r.Failed), len(r.Failed)+len(r.Succeeded), strings.Join(failures, "; "))
+}
+
+type outcomeTracker struct {
+	sync.Mutex
+	retryPolicy   RetryPolicy
+	failurePolicy FailurePolicy
+	succeeded     []string
+	failed        []IntentFailure
+	aborted       bool
+	// failedSources holds the source namespaces of the failed intents
+	failedSources map[string]bool
+}
+
+func newOutcomeTracker() *outcomeTracker {
+	return &outcomeTracker{failedSources: map[string]bool{}}
+}
+
+func (t *outcomeTracker) isAborted() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.aborted
+}
+
+// failedDependency returns the namespace of a failed intent which the given
+// one depends on, if there is one.
+func (t *outcomeTracker) failedDependency(intent *Intent) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+	for _, dep := range intent.Dependencies() {
+		if t.failedSources[dep] {
+			return dep, true
+		}
+	}
+	return "", false
+}
+
+func (t *outcomeTracker) record(intent *Intent, attempts int, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if err == nil {
+		t.succeeded = append(t.succeeded, intent.Namespace())
+		return
+	}
+	t.failed = append(t.failed, IntentFailure{Namespace: intent.Namespace(), Attempts: attempts, Err: err})
+	t.failedSources[intent.sourceNamespace()] = true
+	if t.failurePolicy == FailFast && !t.aborted {
+		log.Logvf(log.Always, "stopping after failure of '%v'", intent.Namespace())
+		t.aborted = true
+	}
+}
+
+// SetRetryPolicy sets how Attempt retries failed intents.
+func (mgr *Manager) SetRetryPolicy(policy RetryPolicy) {
+	mgr.outcomes.Lock()
+	defer mgr.outcomes.Unlock()
+	mgr.outcomes.retryPolicy = policy
+}
+
+// SetFailurePolicy sets whether Pop stops handing out intents after one has
+// failed. The default is FailFast.
+func (mgr *Manager) SetFailurePolicy(policy FailurePolicy) {
+	mgr.outcomes.Lock()
+	defer mgr.outcomes.Unlock()
+	mgr.outcomes.failurePolicy = policy
+}
+
+// FinishWithError tells the prioritizer that the tool is done with the given
+// intent and records whether it failed. Under the FailFast policy, a failure
+// makes Pop return nil from then on.
+func (mgr *Manager) FinishWithError(intent *Intent, err error) {
+	mgr.finishAttempts(intent, 1, err)
+}
+
+func (mgr *Manager) finishAttempts(intent *Intent, attempts int, err error) {
+	mgr.outcomes.record(intent, attempts, err)
+	mgr.prioritizer.Finish(intent)
+}
+
+// Attempt calls fn with the intent, retrying transient errors with backoff
+// according to the retry policy, then finishes the intent with the final
+// result and returns it. The intent keeps its place in the prioritizer while
+// it is retried, so intents which depend on it keep waiting. Retries stop
+// early if the job is stopped by another intent's failure.
+func (mgr *Manager) Attempt(intent *Intent, fn func(*Intent) error) error {
+	mgr.outcomes.Lock()
+	policy := mgr.outcomes.retryPolicy
+	mgr.outcomes.Unlock()
+
+	attempts := 0
+	var err error
+	for {
+		attempts++
+		err = fn(intent)
+		if err == nil || attempts > policy.MaxRetries || !policy.transient(err) || mgr.outcomes.isAborted() {
+			break
+		}
+		wait := policy.backoff(attempts)
+		log.Logvf(log.Always, "attempt %d for '%v' failed, retrying in %v: %v",
+			attempts, intent.Namespace(), wait, err)
+		time.Sleep(wait)
+	}
+	mgr.finishAttempts(intent, attempts, err)
+	return err
+}
+
+// Report returns the outcomes of the intents finished so far, sorted by
+// namespace.
+func (mgr *Manager) Report() Report {
+	mgr.outcomes.Lock()
+	defer mgr.outcomes.Unlock()
+
+	report := Report{
+		Succeeded: append([]string(nil), mgr.outcomes.succeeded...),
+		Failed:    append([]IntentFailure(nil), mgr.outcomes.failed...),
+		Aborted:   mgr.outcomes.aborted,
+	}
+	sort.Strings(report.Succeeded)
+	sort.Slice(report.Failed, func(i, j int) bool {
+		return report.Failed[i].Namespace < report.Failed[j].Namespace
+	})
+	return report
+}
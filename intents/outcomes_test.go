@@ -0,0 +1,149 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("Backoff should double up to the maximum", t, func() {
+		policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond}
+		So(policy.backoff(1), ShouldEqual, 10*time.Millisecond)
+		So(policy.backoff(2), ShouldEqual, 20*time.Millisecond)
+		So(policy.backoff(3), ShouldEqual, 25*time.Millisecond)
+		So(policy.backoff(10), ShouldEqual, 25*time.Millisecond)
+
+		policy.MaxBackoff = 0
+		So(policy.backoff(4), ShouldEqual, 80*time.Millisecond)
+	})
+}
+
+func TestIntentOutcomes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a finalized IntentManager", t, func() {
+		manager := NewIntentManager()
+		manager.Put(&Intent{DB: "a", C: "1"})
+		manager.Put(&Intent{DB: "a", C: "2"})
+		manager.Put(&Intent{DB: "a", C: "3"})
+		manager.SetRetryPolicy(RetryPolicy{
+			MaxRetries:  2,
+			Backoff:     time.Millisecond,
+			IsTransient: func(err error) bool { return err == errTransient },
+		})
+		So(manager.Finalize(Legacy), ShouldBeNil)
+
+		Convey("transient errors should be retried", func() {
+			calls := 0
+			err := manager.Attempt(manager.Pop(), func(*Intent) error {
+				calls++
+				if calls < 3 {
+					return errTransient
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 3)
+			So(manager.Report().Succeeded, ShouldResemble, []string{"a.1"})
+		})
+
+		Convey("retries should stop at the limit", func() {
+			calls := 0
+			err := manager.Attempt(manager.Pop(), func(*Intent) error {
+				calls++
+				return errTransient
+			})
+			So(err, ShouldEqual, errTransient)
+			So(calls, ShouldEqual, 3)
+			So(manager.Report().Failed, ShouldResemble,
+				[]IntentFailure{{Namespace: "a.1", Attempts: 3, Err: errTransient}})
+		})
+
+		Convey("other errors should not be retried", func() {
+			calls := 0
+			permanent := errors.New("permanent")
+			err := manager.Attempt(manager.Pop(), func(*Intent) error {
+				calls++
+				return permanent
+			})
+			So(err, ShouldEqual, permanent)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("by default, a failure should stop the job", func() {
+			manager.FinishWithError(manager.Pop(), errors.New("failed"))
+			So(manager.Pop(), ShouldBeNil)
+
+			report := manager.Report()
+			So(report.Aborted, ShouldBeTrue)
+			So(report.Err().Error(), ShouldEqual, "1 of 1 collections failed: a.1: failed")
+		})
+
+		Convey("with the ContinueOnError policy, every failure should be reported", func() {
+			manager.SetFailurePolicy(ContinueOnError)
+			for intent := manager.Pop(); intent != nil; intent = manager.Pop() {
+				if intent.C == "2" {
+					manager.Finish(intent)
+					continue
+				}
+				manager.FinishWithError(intent, errors.New("failed "+intent.C))
+			}
+
+			report := manager.Report()
+			So(report.Aborted, ShouldBeFalse)
+			So(report.Succeeded, ShouldResemble, []string{"a.2"})
+			So(len(report.Failed), ShouldEqual, 2)
+			So(report.Err().Error(), ShouldEqual, "2 of 3 collections failed: a.1: failed 1; a.3: failed 3")
+		})
+
+		Convey("with the ContinueOnError policy, dependents of a failed intent should fail too", func() {
+			manager := NewIntentManager()
+			manager.Put(&Intent{DB: "a", C: "c", Size: 10})
+			manager.Put(viewIntent("a", "v1", "c"))
+			manager.Put(viewIntent("a", "v2", "v1"))
+			manager.Put(&Intent{DB: "a", C: "other"})
+			So(manager.Finalize(Legacy), ShouldBeNil)
+			manager.SetFailurePolicy(ContinueOnError)
+
+			var popped []string
+			for intent := manager.Pop(); intent != nil; intent = manager.Pop() {
+				popped = append(popped, intent.C)
+				if intent.C == "c" {
+					manager.FinishWithError(intent, errors.New("failed"))
+					continue
+				}
+				manager.Finish(intent)
+			}
+			So(popped, ShouldNotContain, "v1")
+			So(popped, ShouldNotContain, "v2")
+			So(popped, ShouldContain, "other")
+
+			report := manager.Report()
+			So(report.Succeeded, ShouldResemble, []string{"a.other"})
+			So(report.Failed, ShouldResemble, []IntentFailure{
+				{Namespace: "a.c", Attempts: 1, Err: errors.New("failed")},
+				{Namespace: "a.v1", Err: DependencyFailedError{Dependency: "a.c"}},
+				{Namespace: "a.v2", Err: DependencyFailedError{Dependency: "a.v1"}},
+			})
+		})
+
+		Convey("a report without failures should have no error", func() {
+			manager.Finish(manager.Pop())
+			So(manager.Report().Err(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,279 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Plan describes what a finalized Manager will do: every intent, how it is
+// classified and the order in which the prioritizer schedules the regular
+// intents. It can be written as JSON for review and loaded again with
+// ReadPlan and NewIntentManagerFromPlan to run exactly that plan.
+type Plan struct {
+	// Prioritizer names the prioritizer which ordered the intents.
+	Prioritizer string `json:"prioritizer"`
+	// Intents are the regular intents in the order the prioritizer returns
+	// them to a single worker. With several workers, the prioritizer may
+	// interleave them differently.
+	Intents []PlanIntent `json:"intents"`
+
+	Oplog         *PlanIntent  `json:"oplog,omitempty"`
+	OplogConflict bool         `json:"oplogConflict,omitempty"`
+	Users         *PlanIntent  `json:"users,omitempty"`
+	Roles         *PlanIntent  `json:"roles,omitempty"`
+	AuthVersion   *PlanIntent  `json:"authVersion,omitempty"`
+	SystemIndexes []PlanIntent `json:"systemIndexes,omitempty"`
+}
+
+// PlanIntent is the serialized form of an Intent. Open files are not part of
+// a plan; a tool running a loaded plan opens them from the locations.
+type PlanIntent struct {
+	Source           string      `json:"source"`
+	Destination      string      `json:"destination"`
+	Kind             string      `json:"kind,omitempty"`
+	Location         string      `json:"location,omitempty"`
+	MetadataLocation string      `json:"metadataLocation,omitempty"`
+	BSONSize         int64       `json:"bsonSize,omitempty"`
+	Size             int64       `json:"size,omitempty"`
+	DocCount         int64       `json:"docCount,omitempty"`
+	IndexCount       int         `json:"indexCount,omitempty"`
+	UUID             string      `json:"uuid,omitempty"`
+	Options          PlanOptions `json:"options,omitempty"`
+	DependsOn        []string    `json:"dependsOn,omitempty"`
+}
+
+// PlanOptions are collection options, which are serialized as canonical
+// extended JSON to preserve their BSON types.
+type PlanOptions bson.M
+
+func (o PlanOptions) MarshalJSON() ([]byte, error) {
+	return bson.MarshalExtJSON(bson.M(o), true, false)
+}
+
+func (o *PlanOptions) UnmarshalJSON(data []byte) error {
+	var m bson.M
+	if err := bson.UnmarshalExtJSON(data, true, &m); err != nil {
+		return err
+	}
+	*o = PlanOptions(m)
+	return nil
+}
+
+// intentKind classifies an intent for a plan.
+func intentKind(intent *Intent) string {
+	switch {
+	case intent.IsOplog():
+		return "oplog"
+	case intent.IsUsers():
+		return "users"
+	case intent.IsRoles():
+		return "roles"
+	case intent.IsAuthVersion():
+		return "authVersion"
+	case intent.IsSystemIndexes():
+		return "systemIndexes"
+	case intent.IsSystemProfile():
+		return "systemProfile"
+	case intent.IsView():
+		return "view"
+	}
+	return ""
+}
+
+func newPlanIntent(source string, intent *Intent) PlanIntent {
+	return PlanIntent{
+		Source:           source,
+		Destination:      intent.Namespace(),
+		Kind:             intentKind(intent),
+		Location:         intent.Location,
+		MetadataLocation: intent.MetadataLocation,
+		BSONSize:         intent.BSONSize,
+		Size:             intent.Size,
+		DocCount:         intent.DocCount,
+		IndexCount:       intent.IndexCount,
+		UUID:             intent.UUID,
+		Options:          PlanOptions(intent.Options),
+		DependsOn:        intent.DependsOn,
+	}
+}
+
+// Intent returns the intent described by p, without open files.
+func (p PlanIntent) Intent() *Intent {
+	intent := &Intent{
+		Location:         p.Location,
+		MetadataLocation: p.MetadataLocation,
+		BSONSize:         p.BSONSize,
+		Size:             p.Size,
+		DocCount:         p.DocCount,
+		IndexCount:       p.IndexCount,
+		UUID:             p.UUID,
+		Options:          bson.M(p.Options),
+		DependsOn:        p.DependsOn,
+	}
+	intent.DB, intent.C = util.SplitNamespace(p.Destination)
+	if p.Source != p.Destination {
+		intent.source = p.Source
+	}
+	return intent
+}
+
+// planInput is what Plan needs to build the plan of a finalized manager.
+type planInput struct {
+	name           string
+	newPrioritizer func([]*Intent) IntentPrioritizer
+	intents        []*Intent
+	sources        map[*Intent]string
+}
+
+// newPlanInput keeps the intents and their sources, which Finalize releases,
+// so that the plan can be built when it is asked for.
+func (mgr *Manager) newPlanInput(name string, newPrioritizer func([]*Intent) IntentPrioritizer) *planInput {
+	sources := make(map[*Intent]string, len(mgr.intents))
+	for source, intent := range mgr.intents {
+		sources[intent] = source
+	}
+	return &planInput{
+		name:           name,
+		newPrioritizer: newPrioritizer,
+		// some prioritizers sort the intents in place, so keep a copy
+		intents: append([]*Intent(nil), mgr.intentsByDiscoveryOrder...),
+		sources: sources,
+	}
+}
+
+// buildPlan records the intents and the order in which a new prioritizer
+// returns them to a single worker.
+func (mgr *Manager) buildPlan(in *planInput) *Plan {
+	name, sources := in.name, in.sources
+	prioritizer := in.newPrioritizer(in.intents)
+	if cost, ok := prioritizer.(*CostPrioritizer); ok {
+		// a stopped clock keeps instant Finish calls from being taken as
+		// observed throughput
+		start := time.Now()
+		cost.now = func() time.Time { return start }
+	}
+
+	plan := &Plan{Prioritizer: name, Intents: []PlanIntent{}, OplogConflict: mgr.oplogConflict}
+	add := func(source string, intent *Intent) *PlanIntent {
+		p := newPlanIntent(source, intent)
+		return &p
+	}
+	for intent := prioritizer.Get(); intent != nil; intent = prioritizer.Get() {
+		prioritizer.Finish(intent)
+		plan.Intents = append(plan.Intents, newPlanIntent(sources[intent], intent))
+	}
+
+	specialSource := func(intent *Intent) string {
+		for source, special := range mgr.specialIntents {
+			if special == intent {
+				return source
+			}
+		}
+		return intent.Namespace()
+	}
+	if mgr.oplogIntent != nil {
+		plan.Oplog = add(specialSource(mgr.oplogIntent), mgr.oplogIntent)
+	}
+	if mgr.usersIntent != nil {
+		plan.Users = add(specialSource(mgr.usersIntent), mgr.usersIntent)
+	}
+	if mgr.rolesIntent != nil {
+		plan.Roles = add(specialSource(mgr.rolesIntent), mgr.rolesIntent)
+	}
+	if mgr.versionIntent != nil {
+		plan.AuthVersion = add(specialSource(mgr.versionIntent), mgr.versionIntent)
+	}
+	for _, db := range mgr.SystemIndexDBs() {
+		intent := mgr.indexIntents[db]
+		plan.SystemIndexes = append(plan.SystemIndexes, newPlanIntent(specialSource(intent), intent))
+	}
+	sort.Slice(plan.SystemIndexes, func(i, j int) bool {
+		return plan.SystemIndexes[i].Source < plan.SystemIndexes[j].Source
+	})
+	return plan
+}
+
+// Plan returns the plan of the finalized manager, or nil if it has not been
+// finalized or a prioritizer was set with UsePrioritizer. The plan is built
+// by the first call, by running a copy of the prioritizer over the intents,
+// so it should be called before the intents are popped and modified.
+func (mgr *Manager) Plan() *Plan {
+	mgr.planLock.Lock()
+	defer mgr.planLock.Unlock()
+	if mgr.planInput != nil {
+		mgr.plan = mgr.buildPlan(mgr.planInput)
+		mgr.planInput = nil
+	}
+	return mgr.plan
+}
+
+// WriteJSON writes the plan as indented JSON.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing plan: %v", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadPlan reads a plan written by WriteJSON.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var p Plan
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("error reading plan: %v", err)
+	}
+	return &p, nil
+}
+
+// NewIntentManagerFromPlan returns a finalized manager which runs the given
+// plan: Pop returns its regular intents in the plan's order. The intents have
+// no open files.
+func NewIntentManagerFromPlan(plan *Plan) (*Manager, error) {
+	mgr := NewIntentManager()
+	mgr.oplogConflict = plan.OplogConflict
+
+	for _, p := range plan.Intents {
+		if _, exists := mgr.intents[p.Source]; exists {
+			return nil, fmt.Errorf("plan has more than one intent for %v", p.Source)
+		}
+		mgr.putNormalIntentWithNamespace(p.Source, p.Intent())
+	}
+
+	special := func(p *PlanIntent) *Intent {
+		if p == nil {
+			return nil
+		}
+		intent := p.Intent()
+		mgr.specialIntents[p.Source] = intent
+		return intent
+	}
+	mgr.oplogIntent = special(plan.Oplog)
+	mgr.usersIntent = special(plan.Users)
+	mgr.rolesIntent = special(plan.Roles)
+	mgr.versionIntent = special(plan.AuthVersion)
+	for i := range plan.SystemIndexes {
+		intent := special(&plan.SystemIndexes[i])
+		mgr.indexIntents[intent.DB] = intent
+	}
+
+	err := mgr.finalize(plan.Prioritizer, func(intents []*Intent) IntentPrioritizer {
+		return newLegacyPrioritizer(intents)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
@@ -0,0 +1,99 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mockFile stands in for an open BSON file, so that special intents are kept.
+type mockFile struct{ bytes.Buffer }
+
+func (*mockFile) Open() error  { return nil }
+func (*mockFile) Close() error { return nil }
+func (*mockFile) Pos() int64   { return 0 }
+
+func TestManagerPlan(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a finalized IntentManager", t, func() {
+		renamer, err := NewNamespaceRenamer([]string{"a.$coll$"}, []string{"b.$coll$"})
+		So(err, ShouldBeNil)
+		manager := NewIntentManager()
+		manager.SetNamespaceRenamer(renamer)
+		manager.Put(&Intent{DB: "a", C: "small", Location: "/a/small.bson", Size: 10})
+		manager.Put(&Intent{DB: "a", C: "big", Location: "/a/big.bson", Size: 1000, DocCount: 5})
+		manager.Put(&Intent{DB: "a", C: "view", Options: bson.M{"viewOn": "small", "pipeline": bson.A{}}})
+		manager.Put(&Intent{DB: "", C: "oplog", Location: "/oplog.bson"})
+		manager.Put(&Intent{DB: "admin", C: "system.users", BSONFile: &mockFile{}})
+		manager.Put(&Intent{DB: "a", C: "system.indexes", BSONFile: &mockFile{}})
+		So(manager.Plan(), ShouldBeNil)
+		manager.Finalize(LongestTaskFirst)
+		// the plan is only built once it is asked for
+		So(manager.plan, ShouldBeNil)
+
+		plan := manager.Plan()
+		So(plan, ShouldNotBeNil)
+
+		Convey("the plan should have the prioritizer's order and classifications", func() {
+			So(plan.Prioritizer, ShouldEqual, "longestTaskFirst")
+			So(len(plan.Intents), ShouldEqual, 3)
+			So(plan.Intents[0].Source, ShouldEqual, "a.big")
+			So(plan.Intents[0].Destination, ShouldEqual, "b.big")
+			So(plan.Intents[0].Location, ShouldEqual, "/a/big.bson")
+			So(plan.Intents[0].DocCount, ShouldEqual, 5)
+			So(plan.Intents[1].Source, ShouldEqual, "a.small")
+			// the view waits for its source
+			So(plan.Intents[2].Source, ShouldEqual, "a.view")
+			So(plan.Intents[2].Kind, ShouldEqual, "view")
+
+			So(plan.Oplog.Kind, ShouldEqual, "oplog")
+			So(plan.Oplog.Location, ShouldEqual, "/oplog.bson")
+			So(plan.Users.Source, ShouldEqual, "admin.system.users")
+			So(len(plan.SystemIndexes), ShouldEqual, 1)
+			So(plan.SystemIndexes[0].Kind, ShouldEqual, "systemIndexes")
+		})
+
+		Convey("finalizing should not consume the prioritizer", func() {
+			So(manager.Pop().C, ShouldEqual, "big")
+		})
+
+		Convey("a plan read back from JSON should run in the same order", func() {
+			var buf bytes.Buffer
+			So(plan.WriteJSON(&buf), ShouldBeNil)
+			loaded, err := ReadPlan(&buf)
+			So(err, ShouldBeNil)
+			So(loaded, ShouldResemble, plan)
+
+			loadedManager, err := NewIntentManagerFromPlan(loaded)
+			So(err, ShouldBeNil)
+			So(loadedManager.Plan(), ShouldResemble, plan)
+			for _, p := range plan.Intents {
+				intent := loadedManager.Pop()
+				So(intent.Namespace(), ShouldEqual, p.Destination)
+				So(intent.IsView(), ShouldEqual, p.Kind == "view")
+				loadedManager.Finish(intent)
+			}
+			So(loadedManager.Pop(), ShouldBeNil)
+
+			So(loadedManager.Oplog().Location, ShouldEqual, "/oplog.bson")
+			So(loadedManager.Users(), ShouldNotBeNil)
+			So(loadedManager.SystemIndexes("a"), ShouldNotBeNil)
+		})
+
+		Convey("a plan with duplicate sources should be rejected", func() {
+			plan.Intents = append(plan.Intents, plan.Intents[0])
+			_, err := NewIntentManagerFromPlan(plan)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -27,6 +27,12 @@ const (
 	ToolTimeFormat = "2006-01-02T15:04:05.000-0700"
 )
 
+// ANSI escape sequences used to erase lines redrawn in place
+const (
+	cursorUpFormat = "\x1b[%dA"
+	eraseDown      = "\x1b[J"
+)
+
 //// Tool Logger Definition
 
 type ToolLogger struct {
@@ -34,6 +40,9 @@ type ToolLogger struct {
 	writer    io.Writer
 	format    string
 	verbosity int
+	// liveLines is the number of lines at the end of the output which were
+	// written by WriteLive and are erased before anything else is written
+	liveLines int
 }
 
 type VerbosityLevel interface {
@@ -56,6 +65,7 @@ func (tl *ToolLogger) SetVerbosity(level VerbosityLevel) {
 
 func (tl *ToolLogger) SetWriter(writer io.Writer) {
 	tl.writer = writer
+	tl.liveLines = 0
 }
 
 func (tl *ToolLogger) SetDateFormat(dateFormat string) {
@@ -87,9 +97,19 @@ func (tl *ToolLogger) Logv(minVerb int, msg string) {
 }
 
 func (tl *ToolLogger) log(msg string) {
+	tl.eraseLiveLines()
 	fmt.Fprintf(tl.writer, "%v\t%v\n", time.Now().Format(tl.format), msg)
 }
 
+// eraseLiveLines erases the lines last written by WriteLive, leaving the
+// cursor at the start of the first of them. The caller must hold the mutex.
+func (tl *ToolLogger) eraseLiveLines() {
+	if tl.liveLines > 0 {
+		fmt.Fprintf(tl.writer, "\r"+cursorUpFormat+eraseDown, tl.liveLines)
+		tl.liveLines = 0
+	}
+}
+
 func NewToolLogger(verbosity VerbosityLevel) *ToolLogger {
 	tl := &ToolLogger{
 		mutex:  &sync.Mutex{},
@@ -114,6 +134,35 @@ func (tlw *toolLogWriter) Write(message []byte) (int, error) {
 	return len(message), nil
 }
 
+// Fd returns the file descriptor of the file the logger writes to, so that
+// callers can tell when it is a terminal. It returns ^uintptr(0) if the logger
+// does not write to a file.
+func (tlw *toolLogWriter) Fd() uintptr {
+	tlw.logger.mutex.Lock()
+	defer tlw.logger.mutex.Unlock()
+	if f, ok := tlw.logger.writer.(interface{ Fd() uintptr }); ok {
+		return f.Fd()
+	}
+	return ^uintptr(0)
+}
+
+// WriteLive writes the message to the logger's underlying writer as is,
+// without a timestamp or trailing newline, if the writer's verbosity is
+// enabled. The last lines lines of the message are live: they are erased
+// before the next log line or message is written, so that output such as
+// progress bars is redrawn in place on a terminal without overwriting log
+// lines.
+func (tlw *toolLogWriter) WriteLive(message []byte, lines int) (int, error) {
+	if tlw.minVerbosity > tlw.logger.verbosity {
+		return len(message), nil
+	}
+	tlw.logger.mutex.Lock()
+	defer tlw.logger.mutex.Unlock()
+	tlw.logger.eraseLiveLines()
+	tlw.logger.liveLines = lines
+	return tlw.logger.writer.Write(message)
+}
+
 // Writer returns an io.Writer that writes to the logger with
 // the given verbosity
 func (tl *ToolLogger) Writer(minVerb int) io.Writer {
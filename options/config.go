@@ -0,0 +1,112 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package options
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	flags "github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseConfigFile reads the YAML file at the given path and applies its
+// contents to the registered options. Each top-level key must be the long name
+// of an option, such as "username" or "sslCAFile", and each value must be a
+// scalar or, for options that can be repeated, a list of scalars. Options
+// which were already given on the command line are left unchanged; values from
+// the config file replace those read from environment variables.
+//
+// ParseConfigFile must be called after the command line has been parsed.
+func (opts *ToolOptions) ParseConfigFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var config yaml.MapSlice
+	err = yaml.Unmarshal(contents, &config)
+	if err != nil {
+		return fmt.Errorf("error parsing config file %v: %v", path, err)
+	}
+
+	for _, item := range config {
+		name, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("error parsing config file %v: option name %v is not a string", path, item.Key)
+		}
+		values, err := configValues(item.Value)
+		if err != nil {
+			return fmt.Errorf("error parsing config file %v: option '%v' %v", path, name, err)
+		}
+		err = opts.setOptionFromConfig(name, values)
+		if err != nil {
+			return fmt.Errorf("error setting option '%v' from config file %v: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// setOptionFromConfig sets the option with the given long name to the given
+// values, unless the option was set on the command line.
+func (opts *ToolOptions) setOptionFromConfig(name string, values []string) error {
+	option := opts.parser.FindOptionByLongName(name)
+	if option == nil {
+		return fmt.Errorf("unknown option")
+	}
+	if option == opts.parser.FindOptionByLongName("config") {
+		return fmt.Errorf("a config file cannot reference another config file")
+	}
+
+	// Feed the values through the go-flags ini parser, which converts them
+	// to the option's type and, when parsing as defaults, skips any option
+	// that was set on the command line.
+	section := &bytes.Buffer{}
+	for _, value := range values {
+		fmt.Fprintf(section, "%v = %v\n", option.LongNameWithNamespace(), strconv.Quote(value))
+	}
+	iniParser := flags.NewIniParser(opts.parser)
+	iniParser.ParseAsDefaults = true
+	err := iniParser.Parse(section)
+	if iniErr, ok := err.(*flags.IniError); ok {
+		return errors.New(iniErr.Message)
+	}
+	return err
+}
+
+// configValues converts a YAML value to the string values of an option.
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("has no value")
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, elem := range v {
+			if !isConfigScalar(elem) {
+				return nil, fmt.Errorf("must be a list of scalar values")
+			}
+			values = append(values, fmt.Sprint(elem))
+		}
+		return values, nil
+	default:
+		if !isConfigScalar(v) {
+			return nil, fmt.Errorf("must be a scalar value or a list")
+		}
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+func isConfigScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return true
+	}
+	return false
+}
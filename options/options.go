@@ -20,6 +20,7 @@ import (
 	flags "github.com/jessevdk/go-flags"
 	"github.com/mongodb/mongo-tools-common/failpoint"
 	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/password"
 	"github.com/mongodb/mongo-tools-common/util"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
@@ -46,6 +47,29 @@ var (
 const IncompatibleArgsErrorFormat = "illegal argument combination: cannot specify %s and --uri"
 const ConflictingArgsErrorFormat = "illegal argument combination: %s conflicts with --uri"
 
+// Authentication mechanisms which need special handling.
+const (
+	GSSAPIMechanism = "GSSAPI"
+	PLAINMechanism  = "PLAIN"
+	X509Mechanism   = "MONGODB-X509"
+	AWSMechanism    = "MONGODB-AWS"
+)
+
+// The standard AWS environment variables, which supply the credentials for
+// the MONGODB-AWS mechanism when none are given otherwise.
+const (
+	AWSAccessKeyIDEnvVar     = "AWS_ACCESS_KEY_ID"
+	AWSSecretAccessKeyEnvVar = "AWS_SECRET_ACCESS_KEY"
+	AWSSessionTokenEnvVar    = "AWS_SESSION_TOKEN"
+)
+
+// defaultGSSAPIServiceName is the Kerberos service name used when none is given.
+const defaultGSSAPIServiceName = "mongodb"
+
+// EnvVarPrefix is prepended to the upper-cased long name of an option to form
+// the name of the environment variable that sets it, e.g. MONGOTOOLS_USERNAME.
+const EnvVarPrefix = "MONGOTOOLS_"
+
 const deprecationWarningSSLAllow = "WARNING: --sslAllowInvalidCertificates and --sslAllowInvalidHostnames are deprecated, please use --tlsInsecure instead"
 
 // Struct encompassing all of the options that are reused across tools: "help",
@@ -109,8 +133,9 @@ func (ns Namespace) String() string {
 
 // Struct holding generic options
 type General struct {
-	Help    bool `long:"help" description:"print usage"`
-	Version bool `long:"version" description:"print the tool version and exit"`
+	Help    bool   `long:"help" description:"print usage"`
+	Version bool   `long:"version" description:"print the tool version and exit"`
+	Config  string `long:"config" value-name:"<filename>" description:"path to a YAML configuration file; options given on the command line take precedence"`
 
 	MaxProcs   int    `long:"numThreads" hidden:"true"`
 	Failpoints string `long:"failpoints" hidden:"true"`
@@ -156,13 +181,28 @@ type Connection struct {
 type SSL struct {
 	UseSSL              bool   `long:"ssl" description:"connect to a mongod or mongos that has ssl enabled"`
 	SSLCAFile           string `long:"sslCAFile" value-name:"<filename>" description:"the .pem file containing the root certificate chain from the certificate authority"`
-	SSLPEMKeyFile       string `long:"sslPEMKeyFile" value-name:"<filename>" description:"the .pem file containing the certificate and key"`
+	SSLPEMKeyFile       string `long:"sslPEMKeyFile" value-name:"<filename>" description:"the .pem or PKCS#12 file containing the certificate and key"`
 	SSLPEMKeyPassword   string `long:"sslPEMKeyPassword" value-name:"<password>" description:"the password to decrypt the sslPEMKeyFile, if necessary"`
 	SSLCRLFile          string `long:"sslCRLFile" value-name:"<filename>" description:"the .pem file containing the certificate revocation list"`
 	SSLAllowInvalidCert bool   `long:"sslAllowInvalidCertificates" hidden:"true" description:"bypass the validation for server certificates"`
 	SSLAllowInvalidHost bool   `long:"sslAllowInvalidHostnames" hidden:"true" description:"bypass the validation for server name"`
 	SSLFipsMode         bool   `long:"sslFIPSMode" description:"use FIPS mode of the installed openssl library"`
 	TLSInsecure         bool   `long:"tlsInsecure" description:"bypass the validation for server's certificate chain and host name"`
+
+	// tls-prefixed aliases for the ssl options above; see NormalizeTLSAliases
+	UseTLS                        bool   `long:"tls" description:"connect to a mongod or mongos that has TLS enabled (alias for --ssl)"`
+	TLSCAFile                     string `long:"tlsCAFile" value-name:"<filename>" description:"the .pem file containing the root certificate chain from the certificate authority (alias for --sslCAFile)"`
+	TLSCertificateKeyFile         string `long:"tlsCertificateKeyFile" value-name:"<filename>" description:"the .pem or PKCS#12 file containing the certificate and key (alias for --sslPEMKeyFile)"`
+	TLSCertificateKeyFilePassword string `long:"tlsCertificateKeyFilePassword" value-name:"<password>" description:"the password to decrypt the tlsCertificateKeyFile, if necessary (alias for --sslPEMKeyPassword)"`
+	TLSCRLFile                    string `long:"tlsCRLFile" value-name:"<filename>" description:"the .pem file containing the certificate revocation list (alias for --sslCRLFile)"`
+	TLSAllowInvalidCert           bool   `long:"tlsAllowInvalidCertificates" hidden:"true" description:"bypass the validation for server certificates (alias for --sslAllowInvalidCertificates)"`
+	TLSAllowInvalidHost           bool   `long:"tlsAllowInvalidHostnames" hidden:"true" description:"bypass the validation for server name (alias for --sslAllowInvalidHostnames)"`
+	TLSFipsMode                   bool   `long:"tlsFIPSMode" description:"use FIPS mode of the installed openssl library (alias for --sslFIPSMode)"`
+
+	TLSDisabledProtocols string `long:"tlsDisabledProtocols" value-name:"<protocols>" description:"comma-separated list of TLS protocols to disable: TLS1_0, TLS1_1, TLS1_2, TLS1_3 or none"`
+	TLSMinVersion        string `long:"tlsMinVersion" value-name:"<protocol>" description:"the minimum TLS protocol to use: TLS1_0, TLS1_1, TLS1_2 or TLS1_3"`
+	TLSCipherSuites      string `long:"tlsCipherSuites" value-name:"<suites>" description:"comma-separated list of cipher suites to allow for TLS 1.2 and earlier, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"`
+	TLSCheckOCSP         bool   `long:"tlsCheckOCSP" description:"fail to connect if the server staples an OCSP response reporting its certificate as revoked"`
 }
 
 // Struct holding auth-related options
@@ -171,12 +211,18 @@ type Auth struct {
 	Password  string `short:"p" value-name:"<password>" long:"password" description:"password for authentication"`
 	Source    string `long:"authenticationDatabase" value-name:"<database-name>" description:"database that holds the user's credentials"`
 	Mechanism string `long:"authenticationMechanism" value-name:"<mechanism>" description:"authentication mechanism to use"`
+
+	AWSSessionToken string `long:"awsSessionToken" value-name:"<aws-session-token>" description:"session token to authenticate with when using the MONGODB-AWS mechanism, along with the access key ID and secret access key given as the username and password"`
+
+	PasswordProvider string `long:"passwordProvider" value-name:"<provider>" description:"how to obtain the password when none is given: 'prompt' (default), 'file:<path>', 'exec:<command>' or 'env:<variable>'"`
 }
 
 // Struct for Kerberos/GSSAPI-specific options
 type Kerberos struct {
-	Service     string `long:"gssapiServiceName" value-name:"<service-name>" description:"service name to use when authenticating using GSSAPI/Kerberos (default: mongodb)"`
-	ServiceHost string `long:"gssapiHostName" value-name:"<host-name>" description:"hostname to use when authenticating using GSSAPI/Kerberos (default: <remote server's address>)"`
+	Service              string `long:"gssapiServiceName" value-name:"<service-name>" description:"service name to use when authenticating using GSSAPI/Kerberos (default: mongodb)"`
+	ServiceHost          string `long:"gssapiHostName" value-name:"<host-name>" description:"hostname to use when authenticating using GSSAPI/Kerberos (default: <remote server's address>)"`
+	ServiceRealm         string `long:"gssapiServiceRealm" value-name:"<realm>" description:"realm of the service principal to use when authenticating using GSSAPI/Kerberos (default: the client's realm)"`
+	CanonicalizeHostName bool   `long:"gssapiCanonicalizeHostName" description:"canonicalize the remote server's hostname with a reverse DNS lookup when authenticating using GSSAPI/Kerberos"`
 }
 type WriteConcern struct {
 	// Specifies the write concern for each write operation that mongofiles writes to the target database.
@@ -290,6 +336,8 @@ func New(appName, versionStr, gitCommit, usageStr string, enabled EnabledOptions
 			panic(fmt.Errorf("couldn't register URI options"))
 		}
 	}
+	bindEnvVars(opts.parser.Group)
+
 	if opts.MaxProcs <= 0 {
 		opts.MaxProcs = runtime.NumCPU()
 	}
@@ -357,19 +405,205 @@ type URISetter interface {
 	SetOptionsFromURI(connstring.ConnString) error
 }
 
+// IsMechanism returns true if the authentication mechanism is the named one.
+// Mechanism names are case-insensitive.
+func (auth *Auth) IsMechanism(name string) bool {
+	return strings.EqualFold(auth.Mechanism, name)
+}
+
 func (auth *Auth) RequiresExternalDB() bool {
-	return auth.Mechanism == "GSSAPI" || auth.Mechanism == "PLAIN" || auth.Mechanism == "MONGODB-X509"
+	return auth.IsMechanism(GSSAPIMechanism) || auth.IsMechanism(PLAINMechanism) ||
+		auth.IsMechanism(X509Mechanism) || auth.IsMechanism(AWSMechanism)
 }
 
 func (auth *Auth) IsSet() bool {
-	return *auth != Auth{}
+	// a password provider on its own does not amount to any credentials
+	creds := *auth
+	creds.PasswordProvider = ""
+	return creds != Auth{}
 }
 
 // ShouldAskForPassword returns true if the user specifies a username flag
 // but no password, and the authentication mechanism requires a password.
+// MONGODB-AWS is excluded since its secret access key is never prompted for.
 func (auth *Auth) ShouldAskForPassword() bool {
 	return auth.Username != "" && auth.Password == "" &&
-		!(auth.Mechanism == "MONGODB-X509" || auth.Mechanism == "GSSAPI")
+		!(auth.IsMechanism(X509Mechanism) || auth.IsMechanism(GSSAPIMechanism) || auth.IsMechanism(AWSMechanism))
+}
+
+// setAWSCredentialsFromEnv fills in the MONGODB-AWS credentials from the
+// standard AWS environment variables when none were given in the options or
+// the URI. The session token is only read from the environment along with the
+// access key, as it is only valid for the key it was issued with.
+func (auth *Auth) setAWSCredentialsFromEnv() {
+	if !auth.IsMechanism(AWSMechanism) || auth.Username != "" || auth.Password != "" {
+		return
+	}
+	auth.Username = os.Getenv(AWSAccessKeyIDEnvVar)
+	auth.Password = os.Getenv(AWSSecretAccessKeyEnvVar)
+	if auth.AWSSessionToken == "" {
+		auth.AWSSessionToken = os.Getenv(AWSSessionTokenEnvVar)
+	}
+}
+
+// validateAuthMechanism returns an error if the auth options are inconsistent
+// with the MONGODB-X509 or MONGODB-AWS mechanisms.
+func (opts *ToolOptions) validateAuthMechanism() error {
+	auth := opts.Auth
+	if auth.AWSSessionToken != "" && !auth.IsMechanism(AWSMechanism) {
+		return fmt.Errorf("--awsSessionToken can only be used with the %v mechanism", AWSMechanism)
+	}
+
+	switch {
+	case auth.IsMechanism(X509Mechanism):
+		if auth.Password != "" {
+			return fmt.Errorf("a password cannot be specified with the %v mechanism", X509Mechanism)
+		}
+		if opts.SSL == nil || opts.SSLPEMKeyFile == "" {
+			return fmt.Errorf("the %v mechanism requires a client certificate (--sslPEMKeyFile)", X509Mechanism)
+		}
+	case auth.IsMechanism(AWSMechanism):
+		if auth.Username != "" && auth.Password == "" {
+			return fmt.Errorf("the %v mechanism requires a secret access key (--password) along with the access key ID", AWSMechanism)
+		}
+		if auth.Username == "" && auth.Password != "" {
+			return fmt.Errorf("the %v mechanism requires an access key ID (--username) along with the secret access key", AWSMechanism)
+		}
+		if auth.AWSSessionToken != "" && auth.Username == "" {
+			return fmt.Errorf("the %v mechanism requires an access key ID and secret access key along with the session token", AWSMechanism)
+		}
+	default:
+		return nil
+	}
+
+	if auth.Source != "" && auth.Source != "$external" {
+		return fmt.Errorf("the %v mechanism requires the authentication database to be $external", strings.ToUpper(auth.Mechanism))
+	}
+	return nil
+}
+
+// GetPasswordProvider returns the provider to use for obtaining the password
+// when ShouldAskForPassword is true, as chosen by --passwordProvider. The
+// default is to prompt the user.
+func (auth *Auth) GetPasswordProvider() (password.Provider, error) {
+	provider, err := password.ParseProvider(auth.PasswordProvider)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --passwordProvider: %v", err)
+	}
+	return provider, nil
+}
+
+// Validate returns an error if the Kerberos options are inconsistent.
+func (k *Kerberos) Validate() error {
+	if k.CanonicalizeHostName && k.ServiceHost != "" {
+		return fmt.Errorf("illegal argument combination: cannot specify both --gssapiHostName and --gssapiCanonicalizeHostName")
+	}
+	return nil
+}
+
+// MechanismProperties returns the GSSAPI authentication mechanism properties
+// for the options which were set, as expected by the driver's credential.
+func (k *Kerberos) MechanismProperties() map[string]string {
+	props := make(map[string]string)
+	if k.Service != "" {
+		props["SERVICE_NAME"] = k.Service
+	}
+	if k.ServiceHost != "" {
+		props["SERVICE_HOST"] = k.ServiceHost
+	}
+	if k.ServiceRealm != "" {
+		props["SERVICE_REALM"] = k.ServiceRealm
+	}
+	if k.CanonicalizeHostName {
+		props["CANONICALIZE_HOST_NAME"] = "true"
+	}
+	return props
+}
+
+// setOptionsFromMechanismProperties sets the Kerberos options from the
+// authMechanismProperties of a connection string. It is an error for a
+// property to be given different values in the URI and on the command line.
+func (k *Kerberos) setOptionsFromMechanismProperties(props map[string]string) error {
+	upperProps := make(map[string]string, len(props))
+	for key, value := range props {
+		upperProps[strings.ToUpper(key)] = value
+	}
+	// The driver always fills in the default service name, so it can't be told
+	// apart from one given in the URI and must not override the option.
+	if k.Service != "" && upperProps["SERVICE_NAME"] == defaultGSSAPIServiceName {
+		delete(upperProps, "SERVICE_NAME")
+	}
+
+	stringProps := []struct {
+		key, flag string
+		field     *string
+	}{
+		{"SERVICE_NAME", "--gssapiServiceName", &k.Service},
+		{"SERVICE_HOST", "--gssapiHostName", &k.ServiceHost},
+		{"SERVICE_REALM", "--gssapiServiceRealm", &k.ServiceRealm},
+	}
+	for _, prop := range stringProps {
+		value, ok := upperProps[prop.key]
+		if !ok {
+			continue
+		}
+		if *prop.field != "" && *prop.field != value {
+			return fmt.Errorf(ConflictingArgsErrorFormat, prop.flag)
+		}
+		*prop.field = value
+	}
+
+	if value, ok := upperProps["CANONICALIZE_HOST_NAME"]; ok {
+		canonicalize, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("CANONICALIZE_HOST_NAME must be a boolean (true, false, 0, 1) but got '%v'", value)
+		}
+		if k.CanonicalizeHostName && !canonicalize {
+			return fmt.Errorf(ConflictingArgsErrorFormat, "--gssapiCanonicalizeHostName")
+		}
+		k.CanonicalizeHostName = canonicalize
+	}
+
+	return k.Validate()
+}
+
+// NormalizeTLSAliases reconciles each ssl-prefixed option with its
+// tls-prefixed alias. Giving both forms of a string option with different
+// values is an error; otherwise, the value given is copied to both fields, so
+// either can be read afterwards. Boolean options are enabled if either form is
+// given.
+func (ssl *SSL) NormalizeTLSAliases() error {
+	stringAliases := []struct {
+		sslName, tlsName string
+		sslVal, tlsVal   *string
+	}{
+		{"--sslCAFile", "--tlsCAFile", &ssl.SSLCAFile, &ssl.TLSCAFile},
+		{"--sslPEMKeyFile", "--tlsCertificateKeyFile", &ssl.SSLPEMKeyFile, &ssl.TLSCertificateKeyFile},
+		{"--sslPEMKeyPassword", "--tlsCertificateKeyFilePassword", &ssl.SSLPEMKeyPassword, &ssl.TLSCertificateKeyFilePassword},
+		{"--sslCRLFile", "--tlsCRLFile", &ssl.SSLCRLFile, &ssl.TLSCRLFile},
+	}
+	for _, alias := range stringAliases {
+		switch {
+		case *alias.sslVal == "":
+			*alias.sslVal = *alias.tlsVal
+		case *alias.tlsVal == "":
+			*alias.tlsVal = *alias.sslVal
+		case *alias.sslVal != *alias.tlsVal:
+			return fmt.Errorf("illegal argument combination: %v conflicts with %v", alias.sslName, alias.tlsName)
+		}
+	}
+
+	boolAliases := [][2]*bool{
+		{&ssl.UseSSL, &ssl.UseTLS},
+		{&ssl.SSLAllowInvalidCert, &ssl.TLSAllowInvalidCert},
+		{&ssl.SSLAllowInvalidHost, &ssl.TLSAllowInvalidHost},
+		{&ssl.SSLFipsMode, &ssl.TLSFipsMode},
+	}
+	for _, alias := range boolAliases {
+		enabled := *alias[0] || *alias[1]
+		*alias[0], *alias[1] = enabled, enabled
+	}
+	return nil
 }
 
 func NewURI(unparsed string) (*URI, error) {
@@ -442,29 +676,83 @@ func (opts *ToolOptions) GetAuthenticationDatabase() string {
 
 // AddOptions registers an additional options group to this instance
 func (opts *ToolOptions) AddOptions(extraOpts ExtraOptions) {
-	_, err := opts.parser.AddGroup(extraOpts.Name()+" options", "", extraOpts)
+	group, err := opts.parser.AddGroup(extraOpts.Name()+" options", "", extraOpts)
 	if err != nil {
 		panic(fmt.Sprintf("error setting command line options for  %v: %v",
 			extraOpts.Name(), err))
 	}
+	bindEnvVars(group)
 
 	if opts.enabledOptions.URI {
 		opts.URI.extraOptionsRegistry = append(opts.URI.extraOptionsRegistry, extraOpts)
 	}
 }
 
+// bindEnvVars binds every option in the group and its subgroups to an
+// environment variable named by EnvVarName, unless the option already names
+// its own variable with an env tag. Values from the environment have the
+// lowest precedence: they are overridden by the config file, which in turn is
+// overridden by the command line.
+func bindEnvVars(group *flags.Group) {
+	for _, option := range group.Options() {
+		if option.LongName == "" || option.EnvDefaultKey != "" {
+			continue
+		}
+		if option.LongName == "help" || option.LongName == "version" {
+			continue
+		}
+		option.EnvDefaultKey = EnvVarName(option.LongName)
+	}
+	for _, subgroup := range group.Groups() {
+		bindEnvVars(subgroup)
+	}
+}
+
+// EnvVarName returns the name of the environment variable bound to the option
+// with the given long name.
+func EnvVarName(longName string) string {
+	return EnvVarPrefix + strings.ToUpper(longName)
+}
+
 // Parse the command line args.  Returns any extra args not accounted for by
-// parsing, as well as an error if the parsing returns an error.
+// parsing, as well as an error if the parsing returns an error. Options not
+// given on the command line are taken from the --config file, if any, or else
+// from their bound environment variables.
 func (opts *ToolOptions) ParseArgs(args []string) ([]string, error) {
 	args, err := opts.parser.ParseArgs(args)
 	if err != nil {
 		return []string{}, err
 	}
 
+	if opts.General.Config != "" {
+		err = opts.ParseConfigFile(opts.General.Config)
+		if err != nil {
+			return []string{}, err
+		}
+	}
+
 	if opts.SSLAllowInvalidCert || opts.SSLAllowInvalidHost {
 		log.Logvf(log.Always, deprecationWarningSSLAllow)
 	}
 
+	if opts.enabledOptions.Auth {
+		if _, err = opts.Auth.GetPasswordProvider(); err != nil {
+			return []string{}, err
+		}
+	}
+
+	if opts.SSL != nil {
+		if err = opts.SSL.NormalizeTLSAliases(); err != nil {
+			return []string{}, err
+		}
+	}
+
+	if opts.enabledOptions.Auth {
+		if err = opts.Kerberos.Validate(); err != nil {
+			return []string{}, err
+		}
+	}
+
 	failpoint.ParseFailpoints(opts.Failpoints)
 
 	err = opts.NormalizeHostPortURI()
@@ -472,6 +760,13 @@ func (opts *ToolOptions) ParseArgs(args []string) ([]string, error) {
 		return []string{}, err
 	}
 
+	if opts.enabledOptions.Auth {
+		opts.Auth.setAWSCredentialsFromEnv()
+		if err = opts.validateAuthMechanism(); err != nil {
+			return []string{}, err
+		}
+	}
+
 	return args, err
 }
 
@@ -553,6 +848,12 @@ func (opts *ToolOptions) setOptionsFromURI(cs connstring.ConnString) error {
 			opts.Source = cs.AuthSource
 		}
 		opts.Auth.Mechanism = cs.AuthMechanism
+		if token, ok := cs.AuthMechanismProperties["AWS_SESSION_TOKEN"]; ok {
+			if opts.Auth.AWSSessionToken != "" {
+				return fmt.Errorf(IncompatibleArgsErrorFormat, "--awsSessionToken")
+			}
+			opts.Auth.AWSSessionToken = token
+		}
 	}
 	if opts.enabledOptions.Namespace {
 		if opts.Namespace != nil && opts.Namespace.DB != "" {
@@ -576,14 +877,17 @@ func (opts *ToolOptions) setOptionsFromURI(cs connstring.ConnString) error {
 			return fmt.Errorf(ConflictingArgsErrorFormat, "--ssl")
 		}
 		opts.SSL.UseSSL = cs.SSL
+		opts.SSL.UseTLS = cs.SSL
 	}
 
-	if strings.ToLower(cs.AuthMechanism) == "gssapi" {
+	if strings.EqualFold(cs.AuthMechanism, GSSAPIMechanism) {
 		if !BuiltWithGSSAPI {
 			return fmt.Errorf("cannot specify gssapiservicename: tool not built with kerberos support")
 		}
-		opts.Kerberos.Service = cs.AuthMechanismProperties["SERVICE_NAME"]
-		opts.Kerberos.ServiceHost = cs.AuthMechanismProperties["SERVICE_HOST"]
+		err := opts.Kerberos.setOptionsFromMechanismProperties(cs.AuthMechanismProperties)
+		if err != nil {
+			return err
+		}
 	}
 
 	for _, extraOpts := range opts.URI.extraOptionsRegistry {
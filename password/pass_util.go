@@ -21,7 +21,19 @@ import (
 // operating systems that aren't solaris
 
 func IsTerminal() bool {
-	return terminal.IsTerminal(int(syscall.Stdin))
+	return IsTerminalFd(int(syscall.Stdin))
+}
+
+// IsTerminalFd returns true if the given file descriptor refers to a terminal.
+func IsTerminalFd(fd int) bool {
+	return terminal.IsTerminal(fd)
+}
+
+// TerminalWidth returns the width, in columns, of the terminal referred to by
+// the given file descriptor.
+func TerminalWidth(fd int) (int, error) {
+	width, _, err := terminal.GetSize(fd)
+	return width, err
 }
 
 func GetPass() (string, error) {
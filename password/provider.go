@@ -0,0 +1,136 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package password
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Provider names accepted by ParseProvider.
+const (
+	PromptProviderName = "prompt"
+	FileProviderName   = "file"
+	ExecProviderName   = "exec"
+	EnvProviderName    = "env"
+)
+
+// Provider supplies a password when one was not given directly.
+type Provider interface {
+	// Password returns the password, or an error if it could not be obtained.
+	Password() (string, error)
+}
+
+// PromptProvider asks the user for a password with Prompt.
+type PromptProvider struct{}
+
+// Password prompts for and returns the password.
+func (PromptProvider) Password() (string, error) {
+	return Prompt()
+}
+
+// FileProvider reads the password from a file. A single trailing newline is
+// removed, so that files created with echo or a text editor work as expected.
+type FileProvider struct {
+	Path string
+}
+
+// Password returns the contents of the file.
+func (p FileProvider) Password() (string, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading password file: %v", err)
+	}
+	return trimNewline(string(data)), nil
+}
+
+// ExecProvider runs an external command, such as a secrets-manager helper,
+// and uses its standard output as the password. The command is split on white
+// space and run directly rather than through a shell. The command's standard
+// error is passed through to the tool's standard error.
+type ExecProvider struct {
+	Command string
+}
+
+// Password runs the command and returns its output.
+func (p ExecProvider) Password() (string, error) {
+	args := strings.Fields(p.Command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no password command given")
+	}
+	stdout := &bytes.Buffer{}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error running password command '%v': %v", args[0], err)
+	}
+	return trimNewline(stdout.String()), nil
+}
+
+// EnvProvider reads the password from an environment variable.
+type EnvProvider struct {
+	Name string
+}
+
+// Password returns the value of the environment variable. It is an error for
+// the variable to be unset.
+func (p EnvProvider) Password() (string, error) {
+	pass, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %v is not set", p.Name)
+	}
+	return pass, nil
+}
+
+// ParseProvider returns the provider described by spec, which has the form
+// <name> or <name>:<argument>:
+//
+//	prompt             ask the user (the default when spec is empty)
+//	file:<path>        read the password from a file
+//	exec:<command>     run a command and read the password from its output
+//	env:<variable>     read the password from an environment variable
+func ParseProvider(spec string) (Provider, error) {
+	name, arg := spec, ""
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		name, arg = spec[:idx], spec[idx+1:]
+	}
+
+	switch name {
+	case "", PromptProviderName:
+		if arg != "" {
+			return nil, fmt.Errorf("password provider '%v' does not take an argument", PromptProviderName)
+		}
+		return PromptProvider{}, nil
+	case FileProviderName:
+		if arg == "" {
+			return nil, fmt.Errorf("password provider '%v' requires a path", FileProviderName)
+		}
+		return FileProvider{Path: arg}, nil
+	case ExecProviderName:
+		if strings.TrimSpace(arg) == "" {
+			return nil, fmt.Errorf("password provider '%v' requires a command", ExecProviderName)
+		}
+		return ExecProvider{Command: arg}, nil
+	case EnvProviderName:
+		if arg == "" {
+			return nil, fmt.Errorf("password provider '%v' requires a variable name", EnvProviderName)
+		}
+		return EnvProvider{Name: arg}, nil
+	}
+	return nil, fmt.Errorf("unknown password provider '%v'", name)
+}
+
+// trimNewline removes a single trailing newline, including a carriage return.
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
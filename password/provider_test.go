@@ -0,0 +1,92 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package password
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseProvider(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("ParseProvider should return the named provider", t, func() {
+		cases := []struct {
+			spec     string
+			expected Provider
+		}{
+			{"", PromptProvider{}},
+			{"prompt", PromptProvider{}},
+			{"file:/etc/secret", FileProvider{Path: "/etc/secret"}},
+			{"file:C:\\secret.txt", FileProvider{Path: "C:\\secret.txt"}},
+			{"exec:vault read -field=password secret/db", ExecProvider{Command: "vault read -field=password secret/db"}},
+			{"env:DB_PASSWORD", EnvProvider{Name: "DB_PASSWORD"}},
+		}
+		for _, c := range cases {
+			provider, err := ParseProvider(c.spec)
+			So(err, ShouldBeNil)
+			So(provider, ShouldResemble, c.expected)
+		}
+	})
+
+	Convey("ParseProvider should reject invalid specs", t, func() {
+		for _, spec := range []string{"prompt:x", "file", "file:", "exec: ", "env:", "vault"} {
+			_, err := ParseProvider(spec)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestProviders(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("FileProvider should read the file without its trailing newline", t, func() {
+		file, err := ioutil.TempFile("", "password")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		_, err = file.WriteString("s3cret pass\r\n")
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		pass, err := FileProvider{Path: file.Name()}.Password()
+		So(err, ShouldBeNil)
+		So(pass, ShouldEqual, "s3cret pass")
+
+		_, err = FileProvider{Path: file.Name() + ".missing"}.Password()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("EnvProvider should read the variable", t, func() {
+		os.Setenv("PASSWORD_PROVIDER_TEST", "s3cret")
+		defer os.Unsetenv("PASSWORD_PROVIDER_TEST")
+
+		pass, err := EnvProvider{Name: "PASSWORD_PROVIDER_TEST"}.Password()
+		So(err, ShouldBeNil)
+		So(pass, ShouldEqual, "s3cret")
+
+		_, err = EnvProvider{Name: "PASSWORD_PROVIDER_TEST_UNSET"}.Password()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("ExecProvider should use the output of the command", t, func() {
+		if runtime.GOOS == "windows" {
+			SkipSo("echo is not an executable on windows")
+			return
+		}
+		pass, err := ExecProvider{Command: "echo s3cret"}.Password()
+		So(err, ShouldBeNil)
+		So(pass, ShouldEqual, "s3cret")
+
+		_, err = ExecProvider{Command: "false"}.Password()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"fmt"
+	"sync"
+)
+
+// NamedProgressor pairs a Progressor with the name it was attached under.
+type NamedProgressor struct {
+	Name string
+	Progressor
+}
+
+// CompositeProgressor is a Progressor whose progress is the sum of the
+// progress of its children. It also implements Manager, so it can be handed
+// to any code that attaches progressors to a manager. Children are detached
+// automatically once they report that they are complete; their final counts
+// are retained so that the overall total stays accurate.
+type CompositeProgressor struct {
+	sync.Mutex
+
+	children []NamedProgressor
+
+	// running totals of children that have already completed
+	doneCurrent, doneMax int64
+}
+
+// NewComposite constructs an empty CompositeProgressor.
+func NewComposite() *CompositeProgressor {
+	return &CompositeProgressor{}
+}
+
+// Attach registers the given progressor as a child of the composite.
+func (c *CompositeProgressor) Attach(name string, progressor Progressor) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, child := range c.children {
+		if child.Name == name {
+			panic(fmt.Sprintf("progressor with name '%s' already exists in composite", name))
+		}
+	}
+	c.children = append(c.children, NamedProgressor{Name: name, Progressor: progressor})
+}
+
+// Detach removes the child with the given name from the composite. Detaching
+// an unfinished child discards its contribution to the overall progress.
+// Detaching a child that has already been detached automatically is a no-op.
+func (c *CompositeProgressor) Detach(name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, child := range c.children {
+		if child.Name == name {
+			c.children = append(c.children[:i], c.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// Progress returns the summed current and maximum values of all children,
+// including those which have already completed.
+func (c *CompositeProgressor) Progress() (int64, int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.reap()
+	current, max := c.doneCurrent, c.doneMax
+	for _, child := range c.children {
+		childCurrent, childMax := child.Progress()
+		current += childCurrent
+		max += childMax
+	}
+	return current, max
+}
+
+// ActiveChildren returns up to n children which have not yet completed, in
+// the order they were attached, along with the total number of active
+// children. If n is negative, all active children are returned.
+func (c *CompositeProgressor) ActiveChildren(n int) ([]NamedProgressor, int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.reap()
+	total := len(c.children)
+	if n < 0 || n > total {
+		n = total
+	}
+	active := make([]NamedProgressor, n)
+	copy(active, c.children[:n])
+	return active, total
+}
+
+// reap detaches all children that have reached their maximum, folding their
+// counts into the completed totals. Children without a maximum are never
+// considered complete. Must be called with the lock held.
+func (c *CompositeProgressor) reap() {
+	remaining := c.children[:0]
+	for _, child := range c.children {
+		current, max := child.Progress()
+		if max > 0 && current >= max {
+			c.doneCurrent += current
+			c.doneMax += max
+			continue
+		}
+		remaining = append(remaining, child)
+	}
+	// clear the tail so detached progressors can be garbage collected
+	for i := len(remaining); i < len(c.children); i++ {
+		c.children[i] = NamedProgressor{}
+	}
+	c.children = remaining
+}
@@ -0,0 +1,136 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompositeProgressor(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a composite progressor and three children", t, func() {
+		composite := NewComposite()
+		child1 := NewCounter(10)
+		child2 := NewCounter(20)
+		child3 := NewCounter(0)
+		composite.Attach("child1", child1)
+		composite.Attach("child2", child2)
+		composite.Attach("child3", child3)
+
+		Convey("progress should be the sum of the children", func() {
+			child1.Inc(5)
+			child2.Inc(7)
+			child3.Inc(3)
+			current, max := composite.Progress()
+			So(current, ShouldEqual, 15)
+			So(max, ShouldEqual, 30)
+		})
+
+		Convey("attaching a duplicate name should panic", func() {
+			So(func() { composite.Attach("child1", NewCounter(1)) }, ShouldPanic)
+		})
+
+		Convey("a completed child should be detached automatically", func() {
+			child1.Set(10)
+			children, total := composite.ActiveChildren(-1)
+			So(total, ShouldEqual, 2)
+			So(children[0].Name, ShouldEqual, "child2")
+			So(children[1].Name, ShouldEqual, "child3")
+
+			Convey("but should still count towards the overall progress", func() {
+				child2.Inc(4)
+				current, max := composite.Progress()
+				So(current, ShouldEqual, 14)
+				So(max, ShouldEqual, 30)
+			})
+
+			Convey("and detaching it explicitly should be a no-op", func() {
+				So(func() { composite.Detach("child1") }, ShouldNotPanic)
+				current, max := composite.Progress()
+				So(current, ShouldEqual, 10)
+				So(max, ShouldEqual, 30)
+			})
+		})
+
+		Convey("detaching an unfinished child should drop its progress", func() {
+			child2.Inc(7)
+			composite.Detach("child2")
+			current, max := composite.Progress()
+			So(current, ShouldEqual, 0)
+			So(max, ShouldEqual, 10)
+		})
+
+		Convey("ActiveChildren should respect the limit", func() {
+			children, total := composite.ActiveChildren(2)
+			So(total, ShouldEqual, 3)
+			So(len(children), ShouldEqual, 2)
+			So(children[0].Name, ShouldEqual, "child1")
+			So(children[1].Name, ShouldEqual, "child2")
+		})
+	})
+}
+
+func TestBarWriterWithComposite(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	writeBuffer := new(safeBuffer)
+
+	Convey("With a BarWriter watching a composite of 10 children", t, func() {
+		manager := NewBarWriter(writeBuffer, time.Second, 10, false)
+		manager.SetChildBarLimit(3)
+		composite := NewComposite()
+		counters := make([]*CountProgressor, 10)
+		for i := range counters {
+			counters[i] = NewCounter(10)
+			composite.Attach("child"+strconv.Itoa(i), counters[i])
+		}
+		manager.Attach("overall", composite)
+
+		Convey("rendering should draw the overall bar and the first three children", func() {
+			manager.renderAllBars()
+			output := writeBuffer.String()
+			So(output, ShouldContainSubstring, "overall")
+			So(output, ShouldContainSubstring, "child0")
+			So(output, ShouldContainSubstring, "child2")
+			So(output, ShouldNotContainSubstring, "child3")
+			So(output, ShouldContainSubstring, "and 7 more")
+			So(
+				strings.Index(output, "overall"),
+				ShouldBeLessThan,
+				strings.Index(output, "child0"),
+			)
+		})
+
+		Convey("completed children should be replaced by the next active ones", func() {
+			counters[0].Set(10)
+			counters[1].Set(10)
+			manager.renderAllBars()
+			output := writeBuffer.String()
+			So(output, ShouldNotContainSubstring, "child0")
+			So(output, ShouldNotContainSubstring, "child1")
+			So(output, ShouldContainSubstring, "child4")
+			So(output, ShouldContainSubstring, "20/100")
+		})
+
+		Convey("a child limit of zero should only draw the overall bar", func() {
+			manager.SetChildBarLimit(0)
+			manager.renderAllBars()
+			output := writeBuffer.String()
+			So(output, ShouldContainSubstring, "overall")
+			So(output, ShouldNotContainSubstring, "child")
+		})
+
+		Reset(func() { writeBuffer.Reset() })
+	})
+}
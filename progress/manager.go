@@ -7,8 +7,10 @@
 package progress
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,11 +30,19 @@ type Manager interface {
 
 const GridPadding = 2
 
+// DefaultChildBarLimit is the number of active children of a
+// CompositeProgressor that a BarWriter draws beneath the overall bar.
+const DefaultChildBarLimit = 5
+
+// ChildIndent is prepended to the names of child bars of a CompositeProgressor.
+const ChildIndent = "  "
+
 // BarWriter implements Manager. It periodically prints the status of all of its
 // progressors in the form of pretty progress bars. It handles thread-safe
 // synchronized progress bar writing, so that its progressors are written in a
 // group at a given interval. It maintains insertion order when printing, such
-// that new bars appear at the bottom of the group.
+// that new bars appear at the bottom of the group. When its writer is a
+// terminal, the group is redrawn in place rather than appended on each write.
 type BarWriter struct {
 	sync.Mutex
 
@@ -42,6 +52,11 @@ type BarWriter struct {
 	stopChan  chan struct{}
 	barLength int
 	isBytes   bool
+
+	childBarLimit int
+
+	// term is non-nil when the writer is a terminal
+	term *terminal
 }
 
 // NewBarWriter returns an initialized BarWriter with the given bar length and
@@ -53,9 +68,21 @@ func NewBarWriter(w io.Writer, waitTime time.Duration, barLength int, isBytes bo
 		stopChan:  make(chan struct{}),
 		barLength: barLength,
 		isBytes:   isBytes,
+
+		childBarLimit: DefaultChildBarLimit,
+		term:          newTerminal(w),
 	}
 }
 
+// SetChildBarLimit sets the maximum number of active children drawn beneath
+// the bar of any attached CompositeProgressor. A limit of zero draws only the
+// overall bar.
+func (manager *BarWriter) SetChildBarLimit(limit int) {
+	manager.Lock()
+	defer manager.Unlock()
+	manager.childBarLimit = limit
+}
+
 // Attach registers the given progressor with the manager
 func (manager *BarWriter) Attach(name string, progressor Progressor) {
 	pb := &Bar{
@@ -100,9 +127,11 @@ func (manager *BarWriter) Detach(name string) {
 	}
 	if pb.hasRendered {
 		// if we've rendered this bar at least once, render it one last time
-		pb.renderToGridRow(grid)
+		manager.renderBar(grid, pb)
+	}
+	if manager.term == nil {
+		grid.FlushRows(manager.writer)
 	}
-	grid.FlushRows(manager.writer)
 
 	updatedBars := make([]*Bar, 0, len(manager.bars)-1)
 	for _, bar := range manager.bars {
@@ -113,27 +142,88 @@ func (manager *BarWriter) Detach(name string) {
 	}
 
 	manager.bars = updatedBars
+
+	if manager.term != nil {
+		// leave the final state of the detached bar above the redrawn group
+		manager.term.redraw(gridLines(grid), manager.renderLiveLines())
+	}
 }
 
 // helper to render all bars in order
 func (manager *BarWriter) renderAllBars() {
 	manager.Lock()
 	defer manager.Unlock()
+	if manager.term != nil {
+		manager.term.redraw(nil, manager.renderLiveLines())
+		return
+	}
 	grid := &text.GridWriter{
 		ColumnPadding: GridPadding,
 	}
+	rows := 0
 	for _, bar := range manager.bars {
-		bar.renderToGridRow(grid)
+		rows += manager.renderBar(grid, bar)
 	}
 	grid.FlushRows(manager.writer)
 	// add padding of one row if we have more than one active bar
-	if len(manager.bars) > 1 {
+	if rows > 1 {
 		// we just write an empty array here, since a write call of any
 		// length to our log.Writer will trigger a new logline.
 		manager.writer.Write([]byte{})
 	}
 }
 
+// renderLiveLines renders all bars in order and returns the formatted lines.
+// Must be called with the lock held.
+func (manager *BarWriter) renderLiveLines() []string {
+	grid := &text.GridWriter{
+		ColumnPadding: GridPadding,
+	}
+	for _, bar := range manager.bars {
+		manager.renderBar(grid, bar)
+	}
+	return gridLines(grid)
+}
+
+// gridLines returns the formatted rows of the grid.
+func gridLines(grid *text.GridWriter) []string {
+	if len(grid.Grid) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	grid.Flush(buf)
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// renderBar renders the given bar to the grid, followed by the active
+// children of the bar's progressor if it is a CompositeProgressor. It returns
+// the number of rows written.
+func (manager *BarWriter) renderBar(grid *text.GridWriter, bar *Bar) int {
+	bar.renderToGridRow(grid)
+	composite, ok := bar.Watching.(*CompositeProgressor)
+	if !ok || manager.childBarLimit <= 0 {
+		return 1
+	}
+
+	children, total := composite.ActiveChildren(manager.childBarLimit)
+	for _, child := range children {
+		childBar := &Bar{
+			Name:      ChildIndent + child.Name,
+			Watching:  child.Progressor,
+			BarLength: manager.barLength,
+			IsBytes:   manager.isBytes,
+		}
+		childBar.renderToGridRow(grid)
+	}
+	rows := 1 + len(children)
+	if hidden := total - len(children); hidden > 0 {
+		grid.WriteCells("", fmt.Sprintf("%s... and %d more", ChildIndent, hidden))
+		grid.EndRow()
+		rows++
+	}
+	return rows
+}
+
 // Start kicks of the timed batch writing of progress bars.
 func (manager *BarWriter) Start() {
 	if manager.writer == nil {
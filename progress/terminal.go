@@ -0,0 +1,121 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongo-tools-common/password"
+)
+
+// DefaultTerminalWidth is the width assumed when the size of the terminal
+// cannot be determined.
+const DefaultTerminalWidth = 80
+
+// ANSI escape sequences used for in-place redraws
+const (
+	cursorUpFormat = "\x1b[%dA"
+	eraseDown      = "\x1b[J"
+)
+
+// fdWriter is implemented by writers backed by a file descriptor, such as
+// *os.File and the writers returned by log.Writer.
+type fdWriter interface {
+	io.Writer
+	Fd() uintptr
+}
+
+// liveWriter is implemented by writers which format what is written to them,
+// such as the writers returned by log.Writer, but which can also write bytes
+// unformatted. Since their other output is interleaved with redraws, they
+// erase the given number of live lines at the end of p themselves before
+// writing anything else.
+type liveWriter interface {
+	WriteLive(p []byte, lines int) (int, error)
+}
+
+// isTerminalFd is a variable so that tests can simulate a terminal.
+var isTerminalFd = password.IsTerminalFd
+
+// terminal redraws a block of lines in place on a terminal, moving the cursor
+// back over the lines written by the previous redraw before writing new ones.
+type terminal struct {
+	// out receives the redrawn lines
+	out io.Writer
+	// live is set instead of out if the writer erases the live lines itself
+	live liveWriter
+	// width returns the current width of the terminal in columns
+	width func() int
+	// lines is the number of lines written by the previous redraw
+	lines int
+}
+
+// newTerminal returns a terminal for the given writer, or nil if the writer
+// is not a terminal.
+func newTerminal(w io.Writer) *terminal {
+	fw, ok := w.(fdWriter)
+	if !ok {
+		return nil
+	}
+	fd := int(fw.Fd())
+	if !isTerminalFd(fd) {
+		return nil
+	}
+	live, _ := w.(liveWriter)
+	return &terminal{
+		out:  w,
+		live: live,
+		width: func() int {
+			width, err := password.TerminalWidth(fd)
+			if err != nil || width <= 0 {
+				return DefaultTerminalWidth
+			}
+			return width
+		},
+	}
+}
+
+// redraw replaces the lines written by the previous redraw. The permanent
+// lines are written first and are left in the scrollback; the live lines are
+// written below them and will be replaced by the next redraw. Everything is
+// written with a single call to Write, or to WriteLive if the writer erases
+// the live lines itself.
+func (t *terminal) redraw(permanent, live []string) {
+	// leave the last column free so that a full line never wraps
+	width := t.width() - 1
+
+	buf := &bytes.Buffer{}
+	if t.lines > 0 && t.live == nil {
+		fmt.Fprintf(buf, "\r"+cursorUpFormat, t.lines)
+	}
+	buf.WriteString(eraseDown)
+	for _, line := range permanent {
+		buf.WriteString(truncateLine(line, width))
+		buf.WriteByte('\n')
+	}
+	for _, line := range live {
+		buf.WriteString(truncateLine(line, width))
+		buf.WriteByte('\n')
+	}
+	t.lines = len(live)
+	if t.live != nil {
+		t.live.WriteLive(buf.Bytes(), t.lines)
+		return
+	}
+	t.out.Write(buf.Bytes())
+}
+
+// truncateLine shortens the line to at most width characters.
+func truncateLine(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
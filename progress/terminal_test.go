@@ -0,0 +1,160 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package progress
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/password"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTerminalDetection(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("A BarWriter should not treat non-terminal writers as terminals", t, func() {
+		So(NewBarWriter(new(safeBuffer), time.Second, 10, false).term, ShouldBeNil)
+
+		file, err := ioutil.TempFile("", "progress")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		defer file.Close()
+		So(NewBarWriter(file, time.Second, 10, false).term, ShouldBeNil)
+	})
+}
+
+func TestTerminalLogWriter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("With a tool logger writing to a simulated terminal", t, func() {
+		file, err := ioutil.TempFile("", "progress")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		isTerminalFd = func(fd int) bool { return fd == int(file.Fd()) }
+		defer func() { isTerminalFd = password.IsTerminalFd }()
+
+		logger := log.NewToolLogger(nil)
+		logger.SetWriter(file)
+
+		Convey("a BarWriter using the logger's writer should redraw in place", func() {
+			manager := NewBarWriter(logger.Writer(0), time.Second, 10, false)
+			So(manager.term, ShouldNotBeNil)
+			manager.Attach("TEST1", NewCounter(10))
+			manager.renderAllBars()
+			manager.renderAllBars()
+
+			output, err := ioutil.ReadFile(file.Name())
+			So(err, ShouldBeNil)
+			// redraws should not be formatted as log lines
+			So(string(output), ShouldStartWith, eraseDown)
+			So(string(output), ShouldNotContainSubstring, "\t")
+			So(string(output), ShouldContainSubstring, "\n\r\x1b[1A"+eraseDown)
+			So(strings.Count(string(output), "\n"), ShouldEqual, 2)
+		})
+
+		Convey("log lines written between redraws should not be erased", func() {
+			manager := NewBarWriter(logger.Writer(0), time.Second, 10, false)
+			manager.Attach("TEST1", NewCounter(10))
+			manager.renderAllBars()
+			logger.Logv(0, "between redraws")
+			manager.renderAllBars()
+
+			output, err := ioutil.ReadFile(file.Name())
+			So(err, ShouldBeNil)
+			// the bar is erased before the log line, which the next redraw
+			// leaves in place
+			parts := strings.Split(string(output), "between redraws\n")
+			So(len(parts), ShouldEqual, 2)
+			So(parts[0], ShouldEndWith, "\t")
+			So(parts[0], ShouldContainSubstring, "\n\r\x1b[1A"+eraseDown)
+			So(parts[1], ShouldStartWith, eraseDown)
+			So(parts[1], ShouldNotContainSubstring, "\x1b[1A")
+			So(strings.Count(parts[1], "\n"), ShouldEqual, 1)
+		})
+
+		Convey("a BarWriter using a writer above the logger's verbosity should not draw", func() {
+			manager := NewBarWriter(logger.Writer(1), time.Second, 10, false)
+			manager.Attach("TEST1", NewCounter(10))
+			manager.renderAllBars()
+
+			output, err := ioutil.ReadFile(file.Name())
+			So(err, ShouldBeNil)
+			So(string(output), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTerminalRendering(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	writeBuffer := new(safeBuffer)
+
+	Convey("With a BarWriter writing to a 40 column terminal", t, func() {
+		manager := NewBarWriter(writeBuffer, time.Second, 10, false)
+		manager.term = &terminal{out: writeBuffer, width: func() int { return 40 }}
+		progressor := NewCounter(10)
+		manager.Attach("TEST1", progressor)
+		manager.Attach("TEST2-LONGER-NAME", progressor)
+
+		Convey("the first render should not move the cursor", func() {
+			manager.renderAllBars()
+			output := writeBuffer.String()
+			So(output, ShouldStartWith, eraseDown)
+			So(output, ShouldNotContainSubstring, "\x1b[2A")
+			So(strings.Count(output, "\n"), ShouldEqual, 2)
+
+			Convey("and lines should fit within the terminal", func() {
+				for _, line := range strings.Split(strings.TrimPrefix(output, eraseDown), "\n") {
+					So(len(line), ShouldBeLessThan, 40)
+				}
+			})
+
+			Convey("subsequent renders should redraw over the previous lines", func() {
+				writeBuffer.Reset()
+				manager.renderAllBars()
+				output := writeBuffer.String()
+				So(output, ShouldStartWith, "\r\x1b[2A"+eraseDown)
+				So(strings.Count(output, "TEST1"), ShouldEqual, 1)
+			})
+
+			Convey("detaching a bar should leave its final line above the group", func() {
+				writeBuffer.Reset()
+				manager.Detach("TEST1")
+				output := writeBuffer.String()
+				So(output, ShouldStartWith, "\r\x1b[2A"+eraseDown)
+				So(strings.Count(output, "\n"), ShouldEqual, 2)
+				So(
+					strings.Index(output, "TEST1"),
+					ShouldBeLessThan,
+					strings.Index(output, "TEST2"),
+				)
+				So(manager.term.lines, ShouldEqual, 1)
+			})
+		})
+
+		Reset(func() { writeBuffer.Reset() })
+	})
+}
+
+func TestTruncateLine(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("truncateLine should shorten lines by character", t, func() {
+		So(truncateLine("abcdef", 3), ShouldEqual, "abc")
+		So(truncateLine("abc", 3), ShouldEqual, "abc")
+		So(truncateLine("ééé", 2), ShouldEqual, "éé")
+		So(truncateLine("abc", 0), ShouldEqual, "abc")
+	})
+}
@@ -7,6 +7,8 @@
 package signals
 
 import (
+	"context"
+
 	"github.com/mongodb/mongo-tools-common/log"
 	"github.com/mongodb/mongo-tools-common/util"
 
@@ -15,6 +17,18 @@ import (
 	"syscall"
 )
 
+// rootContext is cancelled once the first signal has been handled.
+var rootContext, cancelRootContext = context.WithCancel(context.Background())
+
+// Context returns the tool's root context. It is cancelled when the finalizer
+// given to HandleWithInterrupt returns after the first signal, so that the
+// finalizer can still use it to flush and clean up, and operations which are
+// still running afterwards are interrupted. The db package's helpers which
+// don't take a context use it.
+func Context() context.Context {
+	return rootContext
+}
+
 // Handle is like HandleWithInterrupt but it doesn't take a finalizer and will
 // exit immediately after the first signal is received.
 func Handle() chan struct{} {
@@ -22,10 +36,10 @@ func Handle() chan struct{} {
 }
 
 // HandleWithInterrupt starts a goroutine which listens for SIGTERM, SIGINT, and
-// SIGKILL and explicitly ignores SIGPIPE. It calls the finalizer function when
-// the first signal is received and forcibly terminates the program after the
-// second. If a nil function is provided, the program will exit after the first
-// signal.
+// SIGKILL and explicitly ignores SIGPIPE. It calls the finalizer function and
+// then cancels the root Context when the first signal is received and forcibly
+// terminates the program after the second. If a nil function is provided, the
+// program will exit after the first signal.
 func HandleWithInterrupt(finalizer func()) chan struct{} {
 	finishedChan := make(chan struct{})
 	go handleSignals(finalizer, finishedChan)
@@ -41,12 +55,19 @@ func handleSignals(finalizer func(), finishedChan chan struct{}) {
 	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 	defer signal.Stop(sigChan)
+	waitForSignals(sigChan, finalizer, finishedChan)
+}
+
+// waitForSignals acts on the signals received on sigChan until the program
+// exits or finishedChan is closed.
+func waitForSignals(sigChan <-chan os.Signal, finalizer func(), finishedChan chan struct{}) {
 	if finalizer != nil {
 		select {
 		case sig := <-sigChan:
 			// first signal use finalizer to terminate cleanly
 			log.Logvf(log.Always, "signal '%s' received; attempting to shut down", sig)
 			finalizer()
+			cancelRootContext()
 		case <-finishedChan:
 			return
 		}
@@ -0,0 +1,37 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package signals
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRootContext(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	Convey("The first signal should cancel the root context after finalizing", t, func() {
+		So(Context().Err(), ShouldBeNil)
+
+		sigChan := make(chan os.Signal, 1)
+		finishedChan := make(chan struct{})
+		cancelledInFinalizer := make(chan bool, 1)
+		finalizer := func() {
+			cancelledInFinalizer <- Context().Err() != nil
+			close(finishedChan)
+		}
+
+		sigChan <- syscall.SIGINT
+		waitForSignals(sigChan, finalizer, finishedChan)
+		So(<-cancelledInFinalizer, ShouldBeFalse)
+		So(Context().Err(), ShouldNotBeNil)
+	})
+}
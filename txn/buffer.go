@@ -9,9 +9,15 @@
 package txn
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mongodb/mongo-tools-common/bsonutil"
 	"github.com/mongodb/mongo-tools-common/db"
@@ -40,26 +46,77 @@ type txnState struct {
 	ingestDone chan struct{}
 	ingestErr  error
 	stopChan   chan struct{}
-	startTime  primitive.Timestamp
+	lifecycle  Lifecycle
 	wg         sync.WaitGroup
+
+	// bufferBytes is the BSON size of the ops in buffer, which is only
+	// tracked if the Buffer has a memory limit.  memUsed points to the
+	// Buffer's total.
+	bufferBytes int64
+	memUsed     *int64
+
+	// Once the memory limit is exceeded, the transaction's ops are moved to
+	// spillFile and all further ops are appended to it.
+	spillFile   *os.File
+	spillWriter *bufio.Writer
+
+	// Statistics for Snapshot, which are updated atomically by the ingester.
+	addedAt time.Time
+	opCount int64
+	opBytes int64
+	spilled int32
 }
 
-func newTxnState(op db.Oplog) *txnState {
+func newTxnState(op db.Oplog, memUsed *int64) *txnState {
 	return &txnState{
 		ingestChan: make(chan txnTask),
 		ingestDone: make(chan struct{}),
 		stopChan:   make(chan struct{}),
 		buffer:     make([]db.Oplog, 0),
-		startTime:  op.Timestamp,
+		lifecycle:  Lifecycle{StartTimestamp: op.Timestamp},
+		memUsed:    memUsed,
+		addedAt:    time.Now(),
 	}
 }
 
-// Because state is currently kept in memory, purge merely drops the reference
-// so the GC will eventually clean up.  Eventually, this might clean up a file
-// on disk.
+// purge drops the in-memory ops so the GC will eventually clean up, and
+// removes the spill file, if any.
 func (ts *txnState) purge() error {
+	atomic.AddInt64(ts.memUsed, -ts.bufferBytes)
 	ts.buffer = nil
-	return nil
+	ts.bufferBytes = 0
+
+	if ts.spillFile == nil {
+		return nil
+	}
+	name := ts.spillFile.Name()
+	err := ts.spillFile.Close()
+	ts.spillFile = nil
+	ts.spillWriter = nil
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// BufferOptions configures a Buffer.
+type BufferOptions struct {
+	// MemoryLimit is the approximate number of bytes of transaction ops to
+	// keep in memory across all transactions.  Once it is exceeded, the ops
+	// of the transaction being added to are spilled to a temporary file.
+	// Zero means no limit.
+	MemoryLimit int64
+	// SpillDir is the directory for spill files.  It defaults to the system's
+	// temporary directory.
+	SpillDir string
+	// Filter, if set, is called with the namespace of each op in a
+	// transaction, and ops for which it returns false are dropped during
+	// ingestion instead of being stored.  The namespace of a command is that
+	// of the collection it names, e.g. "db.coll" for {create: "coll"}.
+	Filter func(namespace string) bool
+	// Rename, if set, maps the namespace of each op kept by Filter to the
+	// namespace it should be replayed in.
+	Rename func(namespace string) string
 }
 
 // Buffer stores transaction oplog entries until they are needed
@@ -70,12 +127,22 @@ type Buffer struct {
 	stopped bool
 	txns    map[ID]*txnState
 	wg      sync.WaitGroup
+	opts    BufferOptions
+	memUsed int64
 }
 
-// NewBuffer initializes a transaction oplog buffer.
+// NewBuffer initializes a transaction oplog buffer which keeps all ops in
+// memory.
 func NewBuffer() *Buffer {
+	return NewBufferWithOptions(BufferOptions{})
+}
+
+// NewBufferWithOptions initializes a transaction oplog buffer with the given
+// options.
+func NewBufferWithOptions(opts BufferOptions) *Buffer {
 	return &Buffer{
 		txns: make(map[ID]*txnState),
+		opts: opts,
 	}
 }
 
@@ -90,8 +157,9 @@ func NewBuffer() *Buffer {
 
 // AddOp sends a transaction oplog entry to a background goroutine (starting
 // one for a new transaction ID) for asynchronous pre-processing and storage.
-// If the oplog entry is not a transaction, an error will be returned.  Any
-// errors during processing can be discovered later via the error channel from
+// If the oplog entry is not a transaction, or is out of place in the
+// transaction's lifecycle (e.g. data after a prepare), an error will be
+// returned.  Any errors during processing can be discovered later via the error channel from
 // `GetTxnStream`.
 //
 // Must not be called concurrently with other transaction-related operations.
@@ -112,13 +180,17 @@ func (b *Buffer) AddOp(m Meta, op db.Oplog) error {
 	// Get or initialize transaction state
 	state, ok := b.txns[m.id]
 	if !ok {
-		state = newTxnState(op)
+		state = newTxnState(op, &b.memUsed)
 		b.txns[m.id] = state
 		b.wg.Add(1)
 		state.wg.Add(1)
 		go b.ingester(state)
 	}
 
+	if err := state.lifecycle.advance(m, op.Timestamp); err != nil {
+		return err
+	}
+
 	// Send unless the ingester has shut down, e.g. on error
 	select {
 	case <-state.ingestDone:
@@ -140,9 +212,16 @@ LOOP:
 					state.ingestErr = err
 					break LOOP
 				}
-				// store it
+				// store it, unless it is filtered out
 				for _, op := range innerOps {
-					state.buffer = append(state.buffer, op)
+					op, ok := b.rewrite(op)
+					if !ok {
+						continue
+					}
+					if err = b.store(state, op); err != nil {
+						state.ingestErr = err
+						break LOOP
+					}
 				}
 			}
 			if t.meta.IsFinal() {
@@ -152,6 +231,11 @@ LOOP:
 			break LOOP
 		}
 	}
+	if state.spillWriter != nil {
+		if err := state.spillWriter.Flush(); err != nil && state.ingestErr == nil {
+			state.ingestErr = fmt.Errorf("error writing transaction spill file: %v", err)
+		}
+	}
 	close(state.ingestDone)
 	state.wg.Done()
 	b.wg.Done()
@@ -200,22 +284,117 @@ func (b *Buffer) GetTxnStream(m Meta) (<-chan db.Oplog, <-chan error) {
 	return opChan, errChan
 }
 
-func (b *Buffer) streamer(state *txnState, opChan chan<- db.Oplog, errChan chan<- error) {
-LOOP:
+// store adds an op to the transaction's state, spilling the transaction to
+// disk if the memory limit is exceeded.
+func (b *Buffer) store(state *txnState, op db.Oplog) error {
+	atomic.AddInt64(&state.opCount, 1)
+	if b.opts.MemoryLimit <= 0 {
+		state.buffer = append(state.buffer, op)
+		return nil
+	}
+
+	// sizes are only needed, and only worth serializing ops for, with a limit
+	raw, err := bson.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("error serializing transaction op: %v", err)
+	}
+	size := int64(len(raw))
+	atomic.AddInt64(&state.opBytes, size)
+	if state.spillFile == nil {
+		if atomic.AddInt64(&b.memUsed, size) <= b.opts.MemoryLimit {
+			state.buffer = append(state.buffer, op)
+			state.bufferBytes += size
+			return nil
+		}
+		atomic.AddInt64(&b.memUsed, -size)
+		if err = b.spill(state); err != nil {
+			return err
+		}
+	}
+	if _, err = state.spillWriter.Write(raw); err != nil {
+		return fmt.Errorf("error writing transaction spill file: %v", err)
+	}
+	return nil
+}
+
+// spill moves the transaction's in-memory ops to a new spill file.
+func (b *Buffer) spill(state *txnState) error {
+	f, err := ioutil.TempFile(b.opts.SpillDir, "txn-buffer-*.bson")
+	if err != nil {
+		return fmt.Errorf("error creating transaction spill file: %v", err)
+	}
+	state.spillFile = f
+	state.spillWriter = bufio.NewWriter(f)
+	atomic.StoreInt32(&state.spilled, 1)
 	for _, op := range state.buffer {
-		select {
-		case opChan <- op:
-		case <-state.stopChan:
-			errChan <- ErrTxnAborted
-			break LOOP
+		raw, err := bson.Marshal(op)
+		if err != nil {
+			return fmt.Errorf("error serializing transaction op: %v", err)
+		}
+		if _, err = state.spillWriter.Write(raw); err != nil {
+			return fmt.Errorf("error writing transaction spill file: %v", err)
 		}
 	}
+	atomic.AddInt64(&b.memUsed, -state.bufferBytes)
+	state.buffer = nil
+	state.bufferBytes = 0
+	return nil
+}
+
+func (b *Buffer) streamer(state *txnState, opChan chan<- db.Oplog, errChan chan<- error) {
+	var err error
+	if state.spillFile != nil {
+		err = streamSpillFile(state, opChan)
+	} else {
+		err = streamMemory(state, opChan)
+	}
+	if err != nil {
+		errChan <- err
+	}
 	close(opChan)
 	close(errChan)
 	state.wg.Done()
 	b.wg.Done()
 }
 
+func streamMemory(state *txnState, opChan chan<- db.Oplog) error {
+	for _, op := range state.buffer {
+		select {
+		case opChan <- op:
+		case <-state.stopChan:
+			return ErrTxnAborted
+		}
+	}
+	return nil
+}
+
+// streamSpillFile reads the ops back from the spill file through a separate
+// file handle, which is closed when streaming finishes.
+func streamSpillFile(state *txnState, opChan chan<- db.Oplog) error {
+	f, err := os.Open(state.spillFile.Name())
+	if err != nil {
+		return fmt.Errorf("error reading transaction spill file: %v", err)
+	}
+	source := db.NewDecodedBSONSource(db.NewBufferlessBSONSource(f))
+	defer source.Close()
+
+	for {
+		var op db.Oplog
+		if !source.Next(&op) {
+			break
+		}
+		select {
+		case opChan <- op:
+		case <-state.stopChan:
+			return ErrTxnAborted
+		}
+	}
+	if err = source.Err(); err != nil {
+		return fmt.Errorf("error reading transaction spill file: %v", err)
+	}
+	return nil
+}
+
 // OldestTimestamp returns the timestamp of the oldest buffered transaction, or
 // a zero-value timestamp if no transactions are buffered.  This will include
 // committed transactions until they are purged.
@@ -224,13 +403,37 @@ func (b *Buffer) OldestTimestamp() primitive.Timestamp {
 	defer b.Unlock()
 	oldest := zeroTimestamp
 	for _, v := range b.txns {
-		if oldest == zeroTimestamp || util.TimestampLessThan(v.startTime, oldest) {
-			oldest = v.startTime
+		start := v.lifecycle.StartTimestamp
+		if oldest == zeroTimestamp || util.TimestampLessThan(start, oldest) {
+			oldest = start
 		}
 	}
 	return oldest
 }
 
+// Lifecycle returns the lifecycle of a buffered transaction.
+func (b *Buffer) Lifecycle(m Meta) (Lifecycle, error) {
+	b.Lock()
+	defer b.Unlock()
+	state := b.txns[m.id]
+	if state == nil {
+		return Lifecycle{}, fmt.Errorf("Lifecycle found no state for %v", m.id)
+	}
+	return state.lifecycle, nil
+}
+
+// Lifecycles returns the lifecycles of all buffered transactions, including
+// committed and aborted ones until they are purged.
+func (b *Buffer) Lifecycles() map[ID]Lifecycle {
+	b.Lock()
+	defer b.Unlock()
+	lifecycles := make(map[ID]Lifecycle, len(b.txns))
+	for id, state := range b.txns {
+		lifecycles[id] = state.lifecycle
+	}
+	return lifecycles
+}
+
 // PurgeTxn closes any transaction streams in progress and deletes all oplog
 // entries associated with a transaction.
 //
@@ -255,9 +458,7 @@ func (b *Buffer) PurgeTxn(m Meta) error {
 
 	// Wait for goroutines to terminate, then clean up.
 	state.wg.Wait()
-	state.purge()
-
-	return nil
+	return state.purge()
 }
 
 // Stop shuts down processing and cleans up.  Subsequent calls to Stop() will return nil.
@@ -314,8 +515,8 @@ func extractInnerOps(doc bson.D) ([]db.Oplog, error) {
 		return nil, fmt.Errorf(extractErrorFmt, "applyOps field", "not a BSON array")
 	}
 
-	ops := make([]db.Oplog, len(ao))
-	for i, v := range ao {
+	ops := make([]db.Oplog, 0, len(ao))
+	for _, v := range ao {
 		opDoc, ok := v.(bson.D)
 		if !ok {
 			return nil, fmt.Errorf(extractErrorFmt, "applyOps op", "not a BSON document")
@@ -324,12 +525,104 @@ func extractInnerOps(doc bson.D) ([]db.Oplog, error) {
 		if err != nil {
 			return nil, fmt.Errorf(extractErrorFmt, "applyOps op", err)
 		}
-		ops[i] = *op
+		// flatten nested applyOps
+		if op.Operation == "c" && len(op.Object) > 0 && op.Object[0].Key == "applyOps" {
+			nested, err := extractInnerOps(op.Object)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, nested...)
+			continue
+		}
+		ops = append(ops, *op)
 	}
 
 	return ops, nil
 }
 
+// rewrite applies the Filter and Rename options to an op, returning false if
+// the op should be dropped.
+func (b *Buffer) rewrite(op db.Oplog) (db.Oplog, bool) {
+	if b.opts.Filter == nil && b.opts.Rename == nil {
+		return op, true
+	}
+	if op.Operation == "c" && len(op.Object) > 0 && op.Object[0].Key == "renameCollection" {
+		return b.rewriteRename(op)
+	}
+	namespace, isCommand := opNamespace(op)
+	if b.opts.Filter != nil && !b.opts.Filter(namespace) {
+		return op, false
+	}
+	if b.opts.Rename == nil {
+		return op, true
+	}
+	renamed := b.opts.Rename(namespace)
+	if renamed == namespace {
+		return op, true
+	}
+	if !isCommand {
+		op.Namespace = renamed
+		return op, true
+	}
+	// copy the command rather than modify the caller's oplog entry
+	parts := strings.SplitN(renamed, ".", 2)
+	if len(parts) != 2 {
+		return op, true
+	}
+	op.Namespace = parts[0] + ".$cmd"
+	op.Object = append(bson.D{{op.Object[0].Key, parts[1]}}, op.Object[1:]...)
+	return op, true
+}
+
+// rewriteRename applies the Filter and Rename options to both the source and
+// target namespaces of a renameCollection command, returning false if either
+// is filtered out.
+func (b *Buffer) rewriteRename(op db.Oplog) (db.Oplog, bool) {
+	from, _ := op.Object[0].Value.(string)
+	value, _ := bsonutil.FindValueByKey("to", &op.Object)
+	to, _ := value.(string)
+	if b.opts.Filter != nil && (!b.opts.Filter(from) || !b.opts.Filter(to)) {
+		return op, false
+	}
+	if b.opts.Rename == nil {
+		return op, true
+	}
+	renamedFrom, renamedTo := b.opts.Rename(from), b.opts.Rename(to)
+	if renamedFrom == from && renamedTo == to {
+		return op, true
+	}
+	// copy the command rather than modify the caller's oplog entry
+	object := make(bson.D, len(op.Object))
+	copy(object, op.Object)
+	for i := range object {
+		switch object[i].Key {
+		case "renameCollection":
+			object[i].Value = renamedFrom
+		case "to":
+			object[i].Value = renamedTo
+		}
+	}
+	fromDB, _ := util.SplitNamespace(renamedFrom)
+	op.Namespace = fromDB + ".$cmd"
+	op.Object = object
+	return op, true
+}
+
+// opNamespace returns the namespace an op applies to and whether the op is a
+// command naming a collection in its first field, e.g. {create: "coll"}.
+// Commands naming full namespaces, i.e. renameCollection, are handled by
+// rewriteRename instead.
+func opNamespace(op db.Oplog) (string, bool) {
+	if op.Operation != "c" || len(op.Object) == 0 {
+		return op.Namespace, false
+	}
+	coll, ok := op.Object[0].Value.(string)
+	if !ok {
+		return op.Namespace, false
+	}
+	return strings.TrimSuffix(op.Namespace, ".$cmd") + "." + coll, true
+}
+
 const opConvertErrorFmt = "error converting bson.D to op: %s: %v"
 
 func bsonDocToOplog(doc bson.D) (*db.Oplog, error) {
@@ -1,8 +1,14 @@
 package txn
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/mongodb/mongo-tools-common/bsonutil"
 	"github.com/mongodb/mongo-tools-common/db"
 	"github.com/mongodb/mongo-tools-common/testtype"
 	"github.com/mongodb/mongo-tools-common/testutil"
@@ -183,3 +189,220 @@ func TestOldestTimestamp(t *testing.T) {
 	}
 
 }
+
+func TestSpillingTxnBuffer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	spillDir, err := ioutil.TempDir("", "txn_buffer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	// A limit smaller than any op spills every transaction.
+	buffer := NewBufferWithOptions(BufferOptions{MemoryLimit: 1, SpillDir: spillDir})
+	txnByID, err := mapTestTxnByID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streams := make([][]db.Oplog, len(testCases))
+	for i, c := range testCases {
+		streams[i] = c.ops
+	}
+	testBufferOps(t, buffer, txnOps(testutil.MergeOplogStreams(streams)), txnByID)
+	assertSpillFileCount(t, spillDir, 0)
+	if atomic.LoadInt64(&buffer.memUsed) != 0 {
+		t.Errorf("expected no memory in use, but got %d bytes", buffer.memUsed)
+	}
+
+	// Spill files of transactions still open are removed by Stop.
+	for _, op := range testCases[3].ops[:2] {
+		meta, _ := NewMeta(op)
+		if err := buffer.AddOp(meta, op); err != nil {
+			t.Fatalf("AddOp failed: %v", err)
+		}
+	}
+	for i := 0; i < 100 && countSpillFiles(t, spillDir) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertSpillFileCount(t, spillDir, 1)
+	if err := buffer.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	assertSpillFileCount(t, spillDir, 0)
+}
+
+func TestPurgeTxnSpillFileError(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	spillDir, err := ioutil.TempDir("", "txn_buffer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	buffer := NewBufferWithOptions(BufferOptions{MemoryLimit: 1, SpillDir: spillDir})
+	defer buffer.Stop()
+	var meta Meta
+	for _, op := range testCases[3].ops[:2] {
+		meta, _ = NewMeta(op)
+		if err := buffer.AddOp(meta, op); err != nil {
+			t.Fatalf("AddOp failed: %v", err)
+		}
+	}
+	for i := 0; i < 100 && countSpillFiles(t, spillDir) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertSpillFileCount(t, spillDir, 1)
+
+	// a spill file which can't be removed should be reported
+	files, err := ioutil.ReadDir(spillDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(spillDir, files[0].Name())); err != nil {
+		t.Fatal(err)
+	}
+	if err = buffer.PurgeTxn(meta); err == nil {
+		t.Errorf("expected PurgeTxn to fail to remove the spill file")
+	}
+}
+
+func TestPartiallySpillingTxnBuffer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	spillDir, err := ioutil.TempDir("", "txn_buffer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	// A limit large enough for some of the transactions keeps them in memory.
+	buffer := NewBufferWithOptions(BufferOptions{MemoryLimit: 1000, SpillDir: spillDir})
+	defer buffer.Stop()
+	txnByID, err := mapTestTxnByID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			testBufferOps(t, buffer, txnOps(c.ops), txnByID)
+		})
+	}
+	assertSpillFileCount(t, spillDir, 0)
+}
+
+// txnOps returns the ops which are part of transactions, since
+// testBufferOps stops at the first op which is not.
+func txnOps(ops []db.Oplog) []db.Oplog {
+	var filtered []db.Oplog
+	for _, op := range ops {
+		if meta, _ := NewMeta(op); meta.IsTxn() {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+func countSpillFiles(t *testing.T, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func assertSpillFileCount(t *testing.T, dir string, expected int) {
+	if n := countSpillFiles(t, dir); n != expected {
+		t.Errorf("expected %d spill files, but found %d", expected, n)
+	}
+}
+
+func TestNamespaceFilteringTxnBuffer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	buffer := NewBufferWithOptions(BufferOptions{
+		Filter: func(namespace string) bool { return namespace != "test.skip" },
+		Rename: func(namespace string) string {
+			switch namespace {
+			case "test.from":
+				return "renamed.to"
+			case "test.other":
+				return "renamed.other"
+			}
+			return namespace
+		},
+	})
+	defer buffer.Stop()
+
+	txnN := int64(0)
+	op := db.Oplog{
+		Timestamp: primitive.Timestamp{T: 1234, I: 1},
+		LSID:      bson.Raw{0, 0, 0, 0, 1},
+		TxnNumber: &txnN,
+		Operation: "c",
+		Namespace: "admin.$cmd",
+		Object: bson.D{
+			{"applyOps", bson.A{
+				bson.D{{"op", "i"}, {"ns", "test.keep"}, {"o", bson.D{{"_id", 0}}}},
+				bson.D{{"op", "i"}, {"ns", "test.skip"}, {"o", bson.D{{"_id", 1}}}},
+				bson.D{{"op", "c"}, {"ns", "admin.$cmd"}, {"o", bson.D{
+					{"applyOps", bson.A{
+						bson.D{{"op", "c"}, {"ns", "test.$cmd"}, {"o", bson.D{{"create", "skip"}}}},
+						bson.D{{"op", "c"}, {"ns", "test.$cmd"}, {"o", bson.D{{"create", "from"}}}},
+						bson.D{{"op", "u"}, {"ns", "test.from"}, {"o", bson.D{{"$set", bson.D{{"x", 1}}}}}, {"o2", bson.D{{"_id", 2}}}},
+						bson.D{{"op", "c"}, {"ns", "test.$cmd"}, {"o", bson.D{
+							{"renameCollection", "test.from"}, {"to", "test.other"}, {"stayTemp", false},
+						}}},
+						bson.D{{"op", "c"}, {"ns", "test.$cmd"}, {"o", bson.D{
+							{"renameCollection", "test.keep"}, {"to", "test.skip"},
+						}}},
+					}},
+				}}},
+			}},
+		},
+	}
+	meta, err := NewMeta(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = buffer.AddOp(meta, op); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []db.Oplog
+	ops, errs := buffer.GetTxnStream(meta)
+	for op := range ops {
+		got = append(got, op)
+	}
+	if err = <-errs; err != nil {
+		t.Fatalf("GetTxnStream streaming failed: %v", err)
+	}
+
+	expected := []struct{ op, ns, command string }{
+		{"i", "test.keep", ""},
+		{"c", "renamed.$cmd", "to"},
+		{"u", "renamed.to", ""},
+		{"c", "renamed.$cmd", "renamed.to"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d ops, but got %d: %v", len(expected), len(got), got)
+	}
+	for i, e := range expected {
+		if got[i].Operation != e.op || got[i].Namespace != e.ns {
+			t.Errorf("[%d] expected %s op on %s, but got %s op on %s",
+				i, e.op, e.ns, got[i].Operation, got[i].Namespace)
+		}
+		if e.command != "" && got[i].Object[0].Value != e.command {
+			t.Errorf("[%d] expected command on %s, but got %v", i, e.command, got[i].Object)
+		}
+	}
+	rename := got[len(got)-1].Object
+	if to, _ := bsonutil.FindValueByKey("to", &rename); to != "renamed.other" {
+		t.Errorf("expected rename to renamed.other, but got %v", rename)
+	}
+	if err = buffer.PurgeTxn(meta); err != nil {
+		t.Fatal(err)
+	}
+}
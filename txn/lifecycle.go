@@ -0,0 +1,84 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Status is the state of a transaction in its lifecycle.
+type Status int
+
+// Transaction statuses.  A transaction moves from InProgress to Committed or
+// Aborted, optionally passing through Prepared.
+const (
+	InProgress Status = iota
+	Prepared
+	Committed
+	Aborted
+)
+
+func (s Status) String() string {
+	switch s {
+	case InProgress:
+		return "in progress"
+	case Prepared:
+		return "prepared"
+	case Committed:
+		return "committed"
+	case Aborted:
+		return "aborted"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// IsFinal is true if the transaction has been committed or aborted.
+func (s Status) IsFinal() bool {
+	return s == Committed || s == Aborted
+}
+
+// Lifecycle describes where a buffered transaction is in its lifecycle.
+type Lifecycle struct {
+	Status Status
+	// StartTimestamp is the timestamp of the transaction's first entry.
+	StartTimestamp primitive.Timestamp
+	// PrepareTimestamp is the timestamp of the prepare entry, or zero if the
+	// transaction wasn't prepared.
+	PrepareTimestamp primitive.Timestamp
+	// CommitTimestamp is when the transaction's writes become visible, or
+	// zero if it hasn't committed.  See Meta.CommitTimestamp.
+	CommitTimestamp primitive.Timestamp
+}
+
+// WasPrepared is true if the transaction was prepared, including one which
+// was prepared and then committed or aborted.
+func (l Lifecycle) WasPrepared() bool {
+	return l.PrepareTimestamp != zeroTimestamp
+}
+
+// advance moves the lifecycle on according to the next oplog entry of the
+// transaction, which has the given timestamp.
+func (l *Lifecycle) advance(m Meta, ts primitive.Timestamp) error {
+	if l.Status.IsFinal() {
+		return fmt.Errorf("transaction %v received an oplog entry after it was %v", m.id, l.Status)
+	}
+	switch {
+	case m.IsAbort():
+		l.Status = Aborted
+	case m.IsCommit():
+		l.Status = Committed
+		l.CommitTimestamp = m.CommitTimestamp(ts)
+	case l.Status == Prepared:
+		return fmt.Errorf("transaction %v received data after it was prepared", m.id)
+	case m.IsPrepare():
+		l.Status = Prepared
+		l.PrepareTimestamp = ts
+	}
+	return nil
+}
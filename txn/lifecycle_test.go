@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTxnLifecycle(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	for _, c := range testCases {
+		if c.notTxn {
+			continue
+		}
+		t.Run(c.name, func(t *testing.T) {
+			buffer := NewBuffer()
+			defer buffer.Stop()
+
+			for i, op := range c.ops {
+				meta, err := NewMeta(op)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = buffer.AddOp(meta, op); err != nil {
+					t.Fatalf("[%d] AddOp failed: %v", i, err)
+				}
+				lifecycle, err := buffer.Lifecycle(meta)
+				if err != nil {
+					t.Fatalf("[%d] Lifecycle failed: %v", i, err)
+				}
+
+				expected := InProgress
+				switch {
+				case i == len(c.ops)-1 && c.commits:
+					expected = Committed
+				case i == len(c.ops)-1 && c.aborts:
+					expected = Aborted
+				case meta.IsPrepare():
+					expected = Prepared
+				}
+				if lifecycle.Status != expected {
+					t.Errorf("[%d] expected status %v, but got %v", i, expected, lifecycle.Status)
+				}
+				if lifecycle.StartTimestamp != c.ops[0].Timestamp {
+					t.Errorf("[%d] expected start %v, but got %v", i, c.ops[0].Timestamp, lifecycle.StartTimestamp)
+				}
+			}
+
+			lifecycle := buffer.Lifecycles()[mustMeta(t, c.ops[0]).ID()]
+			prepared := len(c.ops) > 1 && mustMeta(t, c.ops[len(c.ops)-2]).IsPrepare()
+			if lifecycle.WasPrepared() != prepared {
+				t.Errorf("expected WasPrepared %v, but got %v", prepared, lifecycle.WasPrepared())
+			}
+			if c.commits == (lifecycle.CommitTimestamp == zeroTimestamp) {
+				t.Errorf("unexpected commit timestamp %v", lifecycle.CommitTimestamp)
+			}
+		})
+	}
+}
+
+func TestTxnCommitTimestamp(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// A prepared transaction commits at the commitTimestamp recorded in its
+	// commit entry, which is before the entry itself.
+	ops := getTestCase(t, "small, prepared, committed").ops
+	buffer := NewBuffer()
+	defer buffer.Stop()
+	for _, op := range ops {
+		if err := buffer.AddOp(mustMeta(t, op), op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lifecycle, err := buffer.Lifecycle(mustMeta(t, ops[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := primitive.Timestamp{T: 1515616500, I: 11}
+	if lifecycle.CommitTimestamp != expected {
+		t.Errorf("expected commit timestamp %v, but got %v", expected, lifecycle.CommitTimestamp)
+	}
+	if lifecycle.PrepareTimestamp != ops[0].Timestamp {
+		t.Errorf("expected prepare timestamp %v, but got %v", ops[0].Timestamp, lifecycle.PrepareTimestamp)
+	}
+
+	// An unprepared transaction commits at its final entry.
+	op := getTestCase(t, "small, unprepared").ops[0]
+	if ts := mustMeta(t, op).CommitTimestamp(op.Timestamp); ts != op.Timestamp {
+		t.Errorf("expected commit timestamp %v, but got %v", op.Timestamp, ts)
+	}
+}
+
+func TestTxnLifecycleErrors(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// Data after a prepare is out of place.
+	ops := getTestCase(t, "small, prepared, committed").ops
+	buffer := NewBuffer()
+	defer buffer.Stop()
+	if err := buffer.AddOp(mustMeta(t, ops[0]), ops[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.AddOp(mustMeta(t, ops[0]), ops[0]); err == nil {
+		t.Errorf("expected an error for data after a prepare")
+	}
+
+	// So is anything after a commit.
+	op := getTestCase(t, "small, unprepared").ops[0]
+	if err := buffer.AddOp(mustMeta(t, op), op); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.AddOp(mustMeta(t, op), op); err == nil {
+		t.Errorf("expected an error for an entry after a commit")
+	}
+}
+
+func mustMeta(t *testing.T, op db.Oplog) Meta {
+	meta, err := NewMeta(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func getTestCase(t *testing.T, name string) *TestData {
+	for _, c := range testCases {
+		if c.name == name {
+			return c
+		}
+	}
+	t.Fatalf("no test case named %q", name)
+	return nil
+}
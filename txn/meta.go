@@ -11,6 +11,7 @@ import (
 	"fmt"
 
 	"github.com/mongodb/mongo-tools-common/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // "empty" prevOpTime is {ts: Timestamp(0, 0), t: NumberLong(-1)} as BSON.
@@ -41,6 +42,8 @@ type Meta struct {
 	partial    bool
 	prepare    bool
 	prevOpTime string
+	// commitTimestamp is set from a commitTransaction command
+	commitTimestamp primitive.Timestamp
 }
 
 // NewMeta extracts transaction metadata from an oplog entry.  A
@@ -73,6 +76,10 @@ func NewMeta(op db.Oplog) (Meta, error) {
 		case "commitTransaction":
 			isRealTxn = true
 			m.commit = true
+		case "commitTimestamp":
+			if ts, ok := e.Value.(primitive.Timestamp); ok {
+				m.commitTimestamp = ts
+			}
 		case "abortTransaction":
 			isRealTxn = true
 			m.abort = true
@@ -91,6 +98,11 @@ func NewMeta(op db.Oplog) (Meta, error) {
 	return m, nil
 }
 
+// ID returns the identifier of the transaction the oplog entry belongs to.
+func (m Meta) ID() ID {
+	return m.id
+}
+
 // IsAbort is true if the oplog entry had the abort command.
 func (m Meta) IsAbort() bool {
 	return m.abort
@@ -107,6 +119,27 @@ func (m Meta) IsCommit() bool {
 	return m.commit || (m.IsTxn() && !m.prepare && !m.partial)
 }
 
+// IsPrepare is true if the oplog entry prepared the transaction, i.e. it is
+// the last data entry of a prepared transaction.
+func (m Meta) IsPrepare() bool {
+	return m.prepare
+}
+
+// CommitTimestamp returns the timestamp at which the transaction's writes
+// become visible.  For a prepared transaction, this is the commitTimestamp
+// recorded by its commitTransaction entry, which is earlier than the entry's
+// own timestamp.  For an unprepared transaction, it is the timestamp of the
+// final entry, opTS.  It is zero if the entry isn't a commit.
+func (m Meta) CommitTimestamp(opTS primitive.Timestamp) primitive.Timestamp {
+	switch {
+	case !m.IsCommit():
+		return zeroTimestamp
+	case m.commit && m.commitTimestamp != zeroTimestamp:
+		return m.commitTimestamp
+	}
+	return opTS
+}
+
 // IsFinal is true if the oplog entry is the closing entry of a transaction,
 // i.e. if IsAbort or IsCommit is true.
 func (m Meta) IsFinal() bool {
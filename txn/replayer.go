@@ -0,0 +1,252 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultReplayBatchSize is the default maximum number of non-transactional
+// oplog entries in a Batch.
+const DefaultReplayBatchSize = 1000
+
+// Batch is a unit of oplog replay: either a run of non-transactional entries
+// or a single committed transaction.
+type Batch struct {
+	// Ops are the non-transactional entries, in order.  It is empty for a
+	// transaction, whose ops are streamed with Replayer.TxnStream.
+	Ops []db.Oplog
+	// Txn is the transaction's metadata, or the zero value for a batch of
+	// non-transactional entries.
+	Txn Meta
+	// Timestamp is the commit timestamp of a transaction, or the timestamp
+	// of the last entry of a batch of non-transactional entries.
+	Timestamp primitive.Timestamp
+
+	seq int
+}
+
+// IsTxn is true if the batch is a committed transaction.
+func (b Batch) IsTxn() bool {
+	return b.Txn.IsTxn()
+}
+
+// replayItem is an entry or a committed transaction waiting to be batched,
+// ordered by its commit timestamp.
+type replayItem struct {
+	ts  primitive.Timestamp
+	op  db.Oplog
+	txn Meta
+}
+
+// Replayer orders a raw oplog stream for replay.  Transaction entries are
+// routed through a Buffer, and Add returns batches in commit timestamp order:
+// runs of non-transactional entries interleaved with committed transactions.
+//
+// A prepared transaction commits at a timestamp earlier than its commit
+// entry, so while any transaction is prepared, entries at or after its
+// prepare timestamp are held back until it commits or aborts.
+//
+// Like Buffer, a Replayer must not be used concurrently.
+type Replayer struct {
+	buffer       *Buffer
+	maxBatchSize int
+
+	// items are waiting to be batched, sorted by timestamp
+	items []replayItem
+	// prepared maps prepared, unfinished transactions to their prepare
+	// timestamps
+	prepared map[ID]primitive.Timestamp
+	// outstanding maps batches which have been returned but not passed to
+	// Done to their oldest timestamps
+	outstanding map[int]primitive.Timestamp
+	nextSeq     int
+}
+
+// NewReplayer returns a replayer which buffers transactions in the given
+// buffer.
+func NewReplayer(buffer *Buffer) *Replayer {
+	return &Replayer{
+		buffer:       buffer,
+		maxBatchSize: DefaultReplayBatchSize,
+		prepared:     make(map[ID]primitive.Timestamp),
+		outstanding:  make(map[int]primitive.Timestamp),
+	}
+}
+
+// SetMaxBatchSize sets the maximum number of non-transactional entries in a
+// batch.
+func (r *Replayer) SetMaxBatchSize(size int) *Replayer {
+	r.maxBatchSize = size
+	return r
+}
+
+// Add routes the next oplog entry and returns any batches which are ready to
+// be applied, in order.  Non-transactional entries are returned once a full
+// batch has accumulated or a transaction commits after them; call Flush to
+// get the rest.
+func (r *Replayer) Add(op db.Oplog) ([]Batch, error) {
+	meta, err := NewMeta(op)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.IsTxn() {
+		r.items = append(r.items, replayItem{ts: op.Timestamp, op: op})
+		return r.batches(false), nil
+	}
+
+	if err = r.buffer.AddOp(meta, op); err != nil {
+		return nil, err
+	}
+	switch {
+	case meta.IsAbort():
+		delete(r.prepared, meta.id)
+		if err = r.buffer.PurgeTxn(meta); err != nil {
+			return nil, err
+		}
+	case meta.IsCommit():
+		delete(r.prepared, meta.id)
+		r.insert(replayItem{ts: meta.CommitTimestamp(op.Timestamp), txn: meta})
+	case meta.IsPrepare():
+		r.prepared[meta.id] = op.Timestamp
+		return nil, nil
+	default:
+		return nil, nil
+	}
+	return r.batches(false), nil
+}
+
+// Flush returns batches of all entries which are ready to be applied,
+// including a final partial batch of non-transactional entries.  Entries held
+// back by a prepared transaction are not returned.
+func (r *Replayer) Flush() []Batch {
+	return r.batches(true)
+}
+
+// insert adds an item in timestamp order.  A prepared transaction's commit
+// timestamp may precede entries which were added before its commit entry.
+func (r *Replayer) insert(item replayItem) {
+	i := sort.Search(len(r.items), func(i int) bool {
+		return util.TimestampGreaterThan(r.items[i].ts, item.ts)
+	})
+	r.items = append(r.items, replayItem{})
+	copy(r.items[i+1:], r.items[i:])
+	r.items[i] = item
+}
+
+// batches removes the items which can no longer be preceded by a commit and
+// groups them into batches.  Unless flushing, a trailing run of
+// non-transactional entries is kept until it fills a batch.
+func (r *Replayer) batches(flush bool) []Batch {
+	ready := len(r.items)
+	if bound, ok := r.preparedBound(); ok {
+		ready = sort.Search(len(r.items), func(i int) bool {
+			return !util.TimestampLessThan(r.items[i].ts, bound)
+		})
+	}
+
+	var batches []Batch
+	var ops []db.Oplog
+	emitOps := func() {
+		batches = append(batches, r.newBatch(Batch{Ops: ops, Timestamp: ops[len(ops)-1].Timestamp}, ops[0].Timestamp))
+		ops = nil
+	}
+
+	consumed := 0
+	for i, item := range r.items[:ready] {
+		if item.txn.IsTxn() {
+			if len(ops) > 0 {
+				emitOps()
+			}
+			batch := Batch{Txn: item.txn, Timestamp: item.ts}
+			start := item.ts
+			if lifecycle, err := r.buffer.Lifecycle(item.txn); err == nil {
+				start = lifecycle.StartTimestamp
+			}
+			batches = append(batches, r.newBatch(batch, start))
+			consumed = i + 1
+			continue
+		}
+		ops = append(ops, item.op)
+		if len(ops) >= r.maxBatchSize {
+			emitOps()
+			consumed = i + 1
+		}
+	}
+	if len(ops) > 0 && flush {
+		emitOps()
+		consumed = ready
+	}
+
+	r.items = r.items[consumed:]
+	return batches
+}
+
+func (r *Replayer) newBatch(batch Batch, oldest primitive.Timestamp) Batch {
+	batch.seq = r.nextSeq
+	r.nextSeq++
+	r.outstanding[batch.seq] = oldest
+	return batch
+}
+
+// preparedBound returns the oldest prepare timestamp of the prepared
+// transactions.  No transaction can commit before it.
+func (r *Replayer) preparedBound() (primitive.Timestamp, bool) {
+	var bound primitive.Timestamp
+	found := false
+	for _, ts := range r.prepared {
+		if !found || util.TimestampLessThan(ts, bound) {
+			bound, found = ts, true
+		}
+	}
+	return bound, found
+}
+
+// TxnStream streams the ops of a transaction batch.  See Buffer.GetTxnStream.
+func (r *Replayer) TxnStream(batch Batch) (<-chan db.Oplog, <-chan error) {
+	return r.buffer.GetTxnStream(batch.Txn)
+}
+
+// Done marks a batch as applied, purging a transaction from the buffer.
+func (r *Replayer) Done(batch Batch) error {
+	if _, ok := r.outstanding[batch.seq]; !ok {
+		return fmt.Errorf("batch %d is not outstanding", batch.seq)
+	}
+	delete(r.outstanding, batch.seq)
+	if batch.IsTxn() {
+		return r.buffer.PurgeTxn(batch.Txn)
+	}
+	return nil
+}
+
+// OldestTimestamp returns the timestamp of the oldest entry which has not
+// been applied yet, counting open transactions from their first entry, or a
+// zero-value timestamp if every entry passed to Add has been applied.  Replay
+// can safely resume from this timestamp, inclusive, after a restart.
+func (r *Replayer) OldestTimestamp() primitive.Timestamp {
+	oldest := r.buffer.OldestTimestamp()
+	older := func(ts primitive.Timestamp) {
+		if oldest == zeroTimestamp || util.TimestampLessThan(ts, oldest) {
+			oldest = ts
+		}
+	}
+	for _, ts := range r.outstanding {
+		older(ts)
+	}
+	for _, item := range r.items {
+		if !item.txn.IsTxn() {
+			older(item.ts)
+			break
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,183 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/testtype"
+	"github.com/mongodb/mongo-tools-common/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func plainOp(ts primitive.Timestamp) db.Oplog {
+	return db.Oplog{
+		Timestamp: ts,
+		Operation: "i",
+		Namespace: "test.plain",
+		Object:    bson.D{{"_id", int32(ts.I)}},
+	}
+}
+
+// applyBatches drains the batches as a replaying tool would, returning the
+// number of transaction ops streamed.
+func applyBatches(t *testing.T, r *Replayer, batches []Batch) int {
+	streamed := 0
+	for _, batch := range batches {
+		if batch.IsTxn() {
+			ops, errs := r.TxnStream(batch)
+			for range ops {
+				streamed++
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("streaming %v failed: %v", batch.Txn.ID(), err)
+			}
+		}
+		if err := r.Done(batch); err != nil {
+			t.Fatalf("Done failed: %v", err)
+		}
+	}
+	return streamed
+}
+
+// The test cases share timestamps, so this only checks that every entry is
+// routed; ordering is checked by TestReplayerPreparedCommitOrder.
+func TestReplayerMixedStream(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	streams := make([][]db.Oplog, len(testCases))
+	expectedTxnOps := 0
+	expectedTxns := 0
+	for i, c := range testCases {
+		streams[i] = c.ops
+		if c.commits {
+			expectedTxnOps += c.innerOpCount
+			expectedTxns++
+		}
+	}
+
+	buffer := NewBuffer()
+	defer buffer.Stop()
+	r := NewReplayer(buffer).SetMaxBatchSize(2)
+
+	var batches []Batch
+	for _, op := range testutil.MergeOplogStreams(streams) {
+		added, err := r.Add(op)
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		batches = append(batches, added...)
+	}
+	batches = append(batches, r.Flush()...)
+
+	txns := 0
+	for i, batch := range batches {
+		if batch.IsTxn() {
+			txns++
+		} else if len(batch.Ops) == 0 || len(batch.Ops) > 2 {
+			t.Errorf("batch %d has %d ops", i, len(batch.Ops))
+		}
+	}
+	if txns != expectedTxns {
+		t.Errorf("expected %d transactions, but got %d", expectedTxns, txns)
+	}
+
+	if streamed := applyBatches(t, r, batches); streamed != expectedTxnOps {
+		t.Errorf("expected %d transaction ops, but got %d", expectedTxnOps, streamed)
+	}
+	if oldest := r.OldestTimestamp(); oldest != zeroTimestamp {
+		t.Errorf("expected zero oldest timestamp after applying everything, but got %v", oldest)
+	}
+	if n := len(buffer.Lifecycles()); n != 0 {
+		t.Errorf("expected an empty buffer, but %d transactions remain", n)
+	}
+}
+
+func TestReplayerPreparedCommitOrder(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// The transaction is prepared at i:1, committed at i:11 and its commit
+	// entry is at i:20.
+	txnOps := getTestCase(t, "small, prepared, committed").ops
+	ts := func(i uint32) primitive.Timestamp { return primitive.Timestamp{T: 1515616500, I: i} }
+
+	buffer := NewBuffer()
+	defer buffer.Stop()
+	r := NewReplayer(buffer)
+
+	add := func(op db.Oplog) []Batch {
+		batches, err := r.Add(op)
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		return batches
+	}
+
+	if batches := add(txnOps[0]); len(batches) != 0 {
+		t.Fatalf("expected no batches after a prepare, but got %d", len(batches))
+	}
+	add(plainOp(ts(5)))
+	add(plainOp(ts(15)))
+
+	// Entries after the prepare are held back until the commit.
+	if batches := r.Flush(); len(batches) != 0 {
+		t.Fatalf("expected entries to be held back, but got %d batches", len(batches))
+	}
+	if oldest := r.OldestTimestamp(); oldest != ts(1) {
+		t.Errorf("expected the prepare to be the oldest timestamp, but got %v", oldest)
+	}
+
+	batches := append(add(txnOps[1]), r.Flush()...)
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches, but got %d", len(batches))
+	}
+	if batches[0].IsTxn() || batches[0].Timestamp != ts(5) {
+		t.Errorf("expected the entry at i:5 first, but got %+v", batches[0])
+	}
+	if !batches[1].IsTxn() || batches[1].Timestamp != ts(11) {
+		t.Errorf("expected the transaction at its commit timestamp second, but got %+v", batches[1])
+	}
+	if batches[2].IsTxn() || batches[2].Timestamp != ts(15) {
+		t.Errorf("expected the entry at i:15 last, but got %+v", batches[2])
+	}
+
+	// Until the transaction is applied, replay must resume from its start.
+	applyBatches(t, r, batches[:1])
+	if oldest := r.OldestTimestamp(); oldest != ts(1) {
+		t.Errorf("expected the transaction's start to be the oldest timestamp, but got %v", oldest)
+	}
+	if streamed := applyBatches(t, r, batches[1:]); streamed != 4 {
+		t.Errorf("expected 4 transaction ops, but got %d", streamed)
+	}
+	if oldest := r.OldestTimestamp(); oldest != zeroTimestamp {
+		t.Errorf("expected zero oldest timestamp, but got %v", oldest)
+	}
+}
+
+func TestReplayerAbortedPrepare(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	txnOps := getTestCase(t, "small, prepared, aborted").ops
+	buffer := NewBuffer()
+	defer buffer.Stop()
+	r := NewReplayer(buffer)
+
+	for _, op := range []db.Oplog{txnOps[0], plainOp(primitive.Timestamp{T: 1515616500, I: 5}), txnOps[1]} {
+		if _, err := r.Add(op); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	batches := r.Flush()
+	if len(batches) != 1 || batches[0].IsTxn() {
+		t.Fatalf("expected only the held entry after the abort, but got %+v", batches)
+	}
+	if n := len(buffer.Lifecycles()); n != 0 {
+		t.Errorf("expected the aborted transaction to be purged, but %d remain", n)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/log"
+	"github.com/mongodb/mongo-tools-common/text"
+	"github.com/mongodb/mongo-tools-common/util"
+)
+
+// TxnStats describes a transaction held by a Buffer.
+type TxnStats struct {
+	// ID is the transaction's ID as a string.
+	ID string
+	Lifecycle
+	// OpCount and Bytes are the number and approximate BSON size of the
+	// transaction's ops ingested so far. Bytes is only tracked if the Buffer
+	// has a memory limit, and is zero otherwise.
+	OpCount int64
+	Bytes   int64
+	// Spilled is true if the transaction's ops are in a spill file.
+	Spilled bool
+	// Age is how long ago the transaction's first entry was added.
+	Age time.Duration
+}
+
+func (s TxnStats) String() string {
+	size := ""
+	if s.Bytes > 0 {
+		size = ", " + text.FormatByteAmount(s.Bytes)
+	}
+	if s.Spilled {
+		size += ", spilled to disk"
+	}
+	return fmt.Sprintf("transaction %v (%v) started at Timestamp(%d, %d) %v ago: %d ops%v",
+		s.ID, s.Status, s.StartTimestamp.T, s.StartTimestamp.I, s.Age.Round(time.Second),
+		s.OpCount, size)
+}
+
+// Snapshot returns statistics on every transaction in the buffer, including
+// committed ones until they are purged, from oldest to newest.
+func (b *Buffer) Snapshot() []TxnStats {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	stats := make([]TxnStats, 0, len(b.txns))
+	for id, state := range b.txns {
+		stats = append(stats, TxnStats{
+			ID:        id.String(),
+			Lifecycle: state.lifecycle,
+			OpCount:   atomic.LoadInt64(&state.opCount),
+			Bytes:     atomic.LoadInt64(&state.opBytes),
+			Spilled:   atomic.LoadInt32(&state.spilled) == 1,
+			Age:       now.Sub(state.addedAt),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return util.TimestampLessThan(stats[i].StartTimestamp, stats[j].StartTimestamp)
+	})
+	return stats
+}
+
+// LongRunning returns the statistics of the transactions in the buffer which
+// are still open, i.e. not committed or aborted, and were started longer ago
+// than the threshold.
+func (b *Buffer) LongRunning(threshold time.Duration) []TxnStats {
+	var long []TxnStats
+	for _, s := range b.Snapshot() {
+		if !s.Status.IsFinal() && s.Age > threshold {
+			long = append(long, s)
+		}
+	}
+	return long
+}
+
+// LogSnapshot logs the statistics of every buffered transaction at the debug
+// level, and warns about open transactions older than warnThreshold.  A zero
+// threshold disables the warnings.
+func (b *Buffer) LogSnapshot(warnThreshold time.Duration) {
+	for _, s := range b.Snapshot() {
+		if warnThreshold > 0 && !s.Status.IsFinal() && s.Age > warnThreshold {
+			log.Logvf(log.Always, "warning: %v; it has been open longer than %v", s, warnThreshold)
+			continue
+		}
+		log.Logvf(log.DebugHigh, "%v", s)
+	}
+}
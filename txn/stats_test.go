@@ -0,0 +1,81 @@
+// Copyright (C) MongoDB, Inc. 2019-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package txn
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools-common/testtype"
+)
+
+func TestBufferSnapshot(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	// a limit which is never reached, so that sizes are tracked
+	buffer := NewBufferWithOptions(BufferOptions{MemoryLimit: 1 << 30})
+	defer buffer.Stop()
+
+	if n := len(buffer.Snapshot()); n != 0 {
+		t.Fatalf("expected an empty snapshot, but got %d transactions", n)
+	}
+
+	// One transaction left open and one committed.
+	open := getTestCase(t, "large, prepared, committed")
+	committed := getTestCase(t, "small, unprepared")
+	for _, op := range append(open.ops[:2:2], committed.ops...) {
+		if err := buffer.AddOp(mustMeta(t, op), op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	openID := mustMeta(t, open.ops[0]).ID().String()
+	committedID := mustMeta(t, committed.ops[0]).ID().String()
+
+	// Ingestion is asynchronous, so wait for the committed transaction's ops.
+	var stats []TxnStats
+	for i := 0; i < 100; i++ {
+		stats = buffer.Snapshot()
+		if len(stats) == 2 && stats[0].OpCount+stats[1].OpCount > int64(committed.innerOpCount) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 transactions, but got %d", len(stats))
+	}
+	for _, s := range stats {
+		switch s.ID {
+		case openID:
+			if s.Status != InProgress || s.OpCount == 0 {
+				t.Errorf("unexpected stats for the open transaction: %v", s)
+			}
+		case committedID:
+			if s.Status != Committed || s.OpCount != int64(committed.innerOpCount) {
+				t.Errorf("unexpected stats for the committed transaction: %v", s)
+			}
+		default:
+			t.Errorf("unexpected transaction %v", s.ID)
+		}
+		if s.Bytes == 0 || s.Spilled {
+			t.Errorf("unexpected size for transaction %v: %v", s.ID, s)
+		}
+		if !strings.Contains(s.String(), s.ID) {
+			t.Errorf("expected %q to contain the ID", s.String())
+		}
+	}
+
+	// Only the open transaction can be long-running.
+	long := buffer.LongRunning(0)
+	if len(long) != 1 || long[0].ID != openID {
+		t.Errorf("expected only the open transaction to be long-running, but got %v", long)
+	}
+	if long := buffer.LongRunning(time.Hour); len(long) != 0 {
+		t.Errorf("expected no transactions open for an hour, but got %v", long)
+	}
+	buffer.LogSnapshot(time.Nanosecond)
+}